@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"resturnat-management/database"
+	"resturnat-management/helper"
+	"resturnat-management/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var apiKeyCollection *mongo.Collection = database.OpenCollection(database.Client, "apiKey")
+
+func CreateApiKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var apiKey models.ApiKey
+
+		if err := c.BindJSON(&apiKey); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(apiKey)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		for _, scope := range apiKey.Scopes {
+			if !helper.IsValidApiKeyScope(scope) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "unknown scope " + scope, "allowed_scopes": helper.ApiKeyScopes})
+				return
+			}
+		}
+
+		apiKey.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		apiKey.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		apiKey.ID = primitive.NewObjectID()
+		apiKey.Api_key_id = apiKey.ID.Hex()
+		apiKey.Created_by = c.GetString("uid")
+		apiKey.Restaurant_id = restaurantID(c)
+		apiKey.Last_used_at = nil
+		apiKey.Revoked_at = nil
+
+		// keys are looked up by their prefix, which is unique. it is short enough to collide
+		// now and then, a new key is generated when it does
+		var plainKey string
+		var insertErr error
+		for attempt := 0; attempt < 3; attempt++ {
+			var err error
+			if plainKey, apiKey.Prefix, apiKey.Key_hash, err = helper.GenerateApiKey(); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while generating the api key"})
+				return
+			}
+			if _, insertErr = apiKeyCollection.InsertOne(ctx, apiKey); !mongo.IsDuplicateKeyError(insertErr) {
+				break
+			}
+		}
+		if insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "api key was not created"})
+			return
+		}
+		recordAudit(ctx, c, "apiKey", apiKey.Api_key_id, AuditCreate, nil, apiKey)
+
+		// the plain key is only ever returned here, we can't recover it later
+		c.JSON(http.StatusCreated, gin.H{"api_key": plainKey, "data": apiKey})
+	}
+}
+
+func GetApiKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		query, err := parsePageQuery(c, []string{"created_at"}, "-created_at")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// decoded as models so the key hash stays out of the response
+		page, err := findPage[models.ApiKey](ctx, apiKeyCollection, tenantFilter(c, bson.M{}), query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing api keys"})
+			return
+		}
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+func RevokeApiKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		apiKeyId := c.Param("api_key_id")
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		// revoking twice keeps the original revocation time
+		filter := tenantFilter(c, bson.M{"api_key_id": apiKeyId})
+		before := snapshot(ctx, apiKeyCollection, filter)
+		result, err := apiKeyCollection.UpdateOne(ctx,
+			tenantFilter(c, bson.M{"api_key_id": apiKeyId, "revoked_at": nil}),
+			bson.M{"$set": bson.M{"revoked_at": now, "updated_at": now}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "api key was not revoked"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "api key not found or already revoked"})
+			return
+		}
+		recordAudit(ctx, c, "apiKey", apiKeyId, AuditUpdate, before, snapshot(ctx, apiKeyCollection, filter))
+		c.JSON(http.StatusOK, gin.H{"message": "api key revoked successfully"})
+	}
+}
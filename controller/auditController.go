@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"resturnat-management/database"
+	"resturnat-management/helper"
+	"resturnat-management/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	AuditCreate = "CREATE"
+	AuditUpdate = "UPDATE"
+	AuditDelete = "DELETE"
+)
+
+// the audit log has always given bigger pages than the other lists
+const (
+	auditDefaultLimit = 100
+	auditMaxLimit     = 500
+)
+
+var auditLogCollection *mongo.Collection = database.OpenCollection(database.Client, "auditLog")
+
+// snapshot returns the document matching the filter as a plain map so it can be diffed,
+// nil when there is no such document yet
+func snapshot(ctx context.Context, collection *mongo.Collection, filter bson.M) bson.M {
+	var doc bson.M
+	if err := collection.FindOne(ctx, filter).Decode(&doc); err != nil {
+		return nil
+	}
+	return doc
+}
+
+// toDocument turns a model into the same plain map shape snapshot returns
+func toDocument(value interface{}) bson.M {
+	data, err := bson.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	return doc
+}
+
+// recordAudit appends who changed what to the audit log. before and after may be models,
+// plain maps from snapshot, or nil. a failure is logged and never fails the request itself
+func recordAudit(ctx context.Context, c *gin.Context, entity, entityId, action string, before, after interface{}) {
+	actorType := c.GetString("auth_type")
+	if actorType == "" {
+		actorType = helper.AuthTypeUser
+	}
+	writeAudit(ctx, restaurantID(c), c.GetString("uid"), actorType, entity, entityId, action, before, after)
+}
+
+// recordSystemAudit records a change the api made by itself, like the scheduler placing a pre-order
+func recordSystemAudit(ctx context.Context, restaurantId, actor, entity, entityId, action string, before, after interface{}) {
+	writeAudit(ctx, restaurantId, actor, helper.AuthTypeSystem, entity, entityId, action, before, after)
+}
+
+func writeAudit(ctx context.Context, restaurantId, actorId, actorType, entity, entityId, action string, before, after interface{}) {
+	beforeDoc, afterDoc := auditDocument(before), auditDocument(after)
+
+	changes := helper.DiffDocuments(beforeDoc, afterDoc)
+	if action == AuditUpdate && len(changes) == 0 {
+		return
+	}
+
+	entry := models.AuditLog{
+		ID:            primitive.NewObjectID(),
+		Restaurant_id: restaurantId,
+		Actor_id:      actorId,
+		Actor_type:    actorType,
+		Entity:        entity,
+		Entity_id:     entityId,
+		Action:        action,
+		Changes:       changes,
+		Created_at:    time.Now().UTC(),
+	}
+	entry.Audit_id = entry.ID.Hex()
+
+	if _, err := auditLogCollection.InsertOne(ctx, entry); err != nil {
+		log.Printf("Failed to write audit log for %s %s: %v", entity, entityId, err)
+	}
+}
+
+func auditDocument(value interface{}) bson.M {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case bson.M:
+		return v
+	default:
+		return toDocument(v)
+	}
+}
+
+// GetAuditLogs lists audit entries a page at a time, newest first, filtered by entity, entity_id, actor
+// and a from/to date range (RFC3339 or YYYY-MM-DD)
+func GetAuditLogs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := tenantFilter(c, bson.M{})
+		if entity := c.Query("entity"); entity != "" {
+			filter["entity"] = entity
+		}
+		if entityId := c.Query("entity_id"); entityId != "" {
+			filter["entity_id"] = entityId
+		}
+		if actor := c.Query("actor"); actor != "" {
+			filter["actor_id"] = actor
+		}
+
+		createdAt, err := parseQueryRange(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if createdAt != nil {
+			filter["created_at"] = createdAt
+		}
+
+		query, err := parsePageQueryLimits(c, []string{"created_at"}, "-created_at", auditDefaultLimit, auditMaxLimit)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		page, err := findPage[models.AuditLog](ctx, auditLogCollection, filter, query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the audit log"})
+			return
+		}
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// parseQueryRange reads the from and to query parameters into a range filter, nil when
+// neither is given
+func parseQueryRange(c *gin.Context) (bson.M, error) {
+	dateRange := bson.M{}
+	if from := c.Query("from"); from != "" {
+		fromTime, err := parseQueryTime(from)
+		if err != nil {
+			return nil, errors.New("from must be RFC3339 or YYYY-MM-DD")
+		}
+		dateRange["$gte"] = fromTime
+	}
+	if to := c.Query("to"); to != "" {
+		toTime, err := parseQueryTime(to)
+		if err != nil {
+			return nil, errors.New("to must be RFC3339 or YYYY-MM-DD")
+		}
+		// a bare date means the whole of that day
+		if len(to) == len("2006-01-02") {
+			toTime = toTime.AddDate(0, 0, 1)
+		}
+		dateRange["$lt"] = toTime
+	}
+	if len(dateRange) == 0 {
+		return nil, nil
+	}
+	return dateRange, nil
+}
+
+func parseQueryTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
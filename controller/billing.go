@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"log"
+	"resturnat-management/helper"
+	"resturnat-management/models"
+	"resturnat-management/pricing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// pricingConfig is the pricing policy from a restaurant's settings, unset rates are zero
+func pricingConfig(setting models.Setting) pricing.Config {
+	config := pricing.Config{Tax_mode: setting.Tax_mode}
+	if setting.Tax_rate != nil {
+		config.Tax_rate = *setting.Tax_rate
+	}
+	if setting.Service_charge_rate != nil {
+		config.Service_charge_rate = *setting.Service_charge_rate
+	}
+	if setting.Service_charge_tax_rate != nil {
+		config.Service_charge_tax_rate = *setting.Service_charge_tax_rate
+	}
+	if setting.Rounding_increment != nil {
+		config.Rounding_increment = *setting.Rounding_increment
+	}
+	return config
+}
+
+func pricingDiscount(discount *models.Discount) *pricing.Discount {
+	if discount == nil {
+		return nil
+	}
+	return &pricing.Discount{Type: discount.Type, Value: discount.Value}
+}
+
+// orderLines are the pricing lines for order items, priced at their snapshotted unit price
+func orderLines(orderItems []models.OrderItem) []pricing.Line {
+	lines := []pricing.Line{}
+	for _, orderItem := range orderItems {
+		// a voided item was never served, so it isn't charged
+		if orderItem.CurrentKitchenStatus() == models.KitchenVoided {
+			continue
+		}
+		line := pricing.Line{
+			Ref:      orderItem.OrderItem_id,
+			Quantity: 1,
+			Tax_rate: orderItem.Tax_rate,
+			Discount: pricingDiscount(orderItem.Discount),
+		}
+		if orderItem.Quantity != nil {
+			line.Quantity = *orderItem.Quantity
+		}
+		if orderItem.Unit_Price != nil {
+			line.Unit_price = *orderItem.Unit_Price
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// priceOrder prices order items with the restaurant's current pricing policy
+func priceOrder(ctx context.Context, restaurantId string, orderItems []models.OrderItem, discount *models.Discount) (pricing.Breakdown, error) {
+	setting, err := loadSettings(ctx, restaurantId)
+	if err != nil {
+		return pricing.Breakdown{}, err
+	}
+	return pricing.Compute(orderLines(orderItems), pricingDiscount(discount), pricingConfig(setting))
+}
+
+// orderBreakdown prices a saved order, it is what an invoice for the order charges
+func orderBreakdown(ctx context.Context, restaurantId, orderId string) (pricing.Breakdown, error) {
+	var order models.Order
+	filter := bson.M{"order_id": orderId, "restaurant_id": restaurantId}
+	if err := orderCollection.FindOne(ctx, filter).Decode(&order); err != nil {
+		return pricing.Breakdown{}, err
+	}
+
+	cursor, err := orderItemCollection.Find(ctx, filter)
+	if err != nil {
+		return pricing.Breakdown{}, err
+	}
+	var orderItems []models.OrderItem
+	if err := cursor.All(ctx, &orderItems); err != nil {
+		return pricing.Breakdown{}, err
+	}
+	return priceOrder(ctx, restaurantId, orderItems, order.Discount)
+}
+
+// allowedDiscount checks a discount a caller wants to give. discounts change what is
+// charged, so like a price override they need the price override permission.
+// a zero value takes the discount away, which is returned as nil
+func allowedDiscount(c *gin.Context, discount *models.Discount) (*models.Discount, error) {
+	if err := pricing.CheckDiscount(pricingDiscount(discount)); err != nil {
+		return nil, err
+	}
+	if !callerHasPermission(c, helper.PermPriceOverride) {
+		return nil, errors.New("only a manager can give a discount")
+	}
+	if discount.Value == 0 {
+		return nil, nil
+	}
+	return discount, nil
+}
+
+// refreshInvoiceTotals re-prices the unpaid invoices of an order after its items changed.
+// paid and voided invoices keep the totals they had. a failure is only logged, the invoice
+// view always prices the order afresh
+func refreshInvoiceTotals(ctx context.Context, restaurantId, orderId string) {
+	totals, err := orderBreakdown(ctx, restaurantId, orderId)
+	if err != nil {
+		log.Printf("invoice totals of order %s were not refreshed: %v", orderId, err)
+		return
+	}
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	unpaid := bson.M{"order_id": orderId, "restaurant_id": restaurantId, "payment_status": bson.M{"$nin": bson.A{"COMPLETED", "VOIDED"}}}
+	err = writeWithEvents(ctx, func(sc mongo.SessionContext) ([]models.OutboxEvent, error) {
+		if _, err := invoiceColletion.UpdateMany(sc, unpaid, bson.M{"$set": bson.M{"totals": totals, "updated_at": now}}); err != nil {
+			return nil, err
+		}
+		var invoices []models.Invoice
+		cursor, err := invoiceColletion.Find(sc, unpaid)
+		if err == nil {
+			err = cursor.All(sc, &invoices)
+		}
+		events := []models.OutboxEvent{}
+		for _, invoice := range invoices {
+			events = append(events, invoiceEvent(models.EventInvoiceUpdated, invoice))
+		}
+		return events, err
+	})
+	if err != nil {
+		log.Printf("invoice totals of order %s were not refreshed: %v", orderId, err)
+	}
+}
@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"context"
+	"os"
+	"resturnat-management/database"
+	"resturnat-management/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var foodOverrideCollection *mongo.Collection = database.OpenCollection(database.Client, "foodOverride")
+
+// the head office owns the central menu, its menus and foods are inherited by every branch
+func headOfficeID() string {
+	return os.Getenv("HEAD_OFFICE_RESTAURANT_ID")
+}
+
+// catalogRestaurantIDs are the restaurants whose menus and foods a branch can see
+func catalogRestaurantIDs(restaurantId string) bson.A {
+	headOffice := headOfficeID()
+	if headOffice == "" || headOffice == restaurantId {
+		return bson.A{restaurantId}
+	}
+	return bson.A{restaurantId, headOffice}
+}
+
+// catalogFilter is tenantFilter for menus and foods, it also matches head office documents
+func catalogFilter(c *gin.Context, filter bson.M) bson.M {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	filter["restaurant_id"] = bson.M{"$in": catalogRestaurantIDs(restaurantID(c))}
+	return filter
+}
+
+// catalogLookup is tenantLookup for menus and foods, it also joins head office documents
+func catalogLookup(from, localField, foreignField, as, restaurantId string) bson.D {
+	return scopedLookup(from, localField, foreignField, as, catalogRestaurantIDs(restaurantId))
+}
+
+// foodOverrideStages merge the branch's override into the foods flowing through a pipeline.
+// path is where the food sits in each document, empty when the foods are the documents themselves
+func foodOverrideStages(restaurantId, path string) []bson.D {
+	prefix := ""
+	if path != "" {
+		prefix = path + "."
+	}
+
+	lookupStage := bson.D{{
+		Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: "foodOverride"},
+			{Key: "let", Value: bson.D{{Key: "food_id", Value: "$" + prefix + "food_id"}}},
+			{Key: "pipeline", Value: bson.A{
+				bson.D{{Key: "$match", Value: bson.D{
+					{Key: "$expr", Value: bson.D{{Key: "$and", Value: bson.A{
+						bson.D{{Key: "$eq", Value: bson.A{"$food_id", "$$food_id"}}},
+						bson.D{{Key: "$eq", Value: bson.A{"$restaurant_id", restaurantId}}},
+					}}}},
+				}}},
+			}},
+			{Key: "as", Value: "_override"},
+		},
+	}}
+
+	unwindStage := bson.D{{
+		Key: "$unwind", Value: bson.D{
+			{Key: "path", Value: "$_override"},
+			{Key: "preserveNullAndEmptyArrays", Value: true},
+		},
+	}}
+
+	// fields the branch hasn't overridden fall through to the head office value
+	mergeStage := bson.D{{
+		Key: "$set", Value: bson.D{
+			{Key: prefix + "price", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$_override.price", "$" + prefix + "price"}}}},
+			{Key: prefix + "food_image", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$_override.food_image", "$" + prefix + "food_image"}}}},
+			{Key: prefix + "available", Value: bson.D{{Key: "$ifNull", Value: bson.A{
+				"$_override.available",
+				bson.D{{Key: "$ifNull", Value: bson.A{"$" + prefix + "available", true}}},
+			}}}},
+			{Key: prefix + "is_overridden", Value: bson.D{{Key: "$ne", Value: bson.A{bson.D{{Key: "$type", Value: "$_override"}}, "missing"}}}},
+		},
+	}}
+
+	unsetStage := bson.D{{Key: "$unset", Value: "_override"}}
+
+	return []bson.D{lookupStage, unwindStage, mergeStage, unsetStage}
+}
+
+// findEffectiveFood returns a food the branch can see with the branch's override applied
+func findEffectiveFood(ctx context.Context, restaurantId, foodId string) (models.Food, error) {
+	var food models.Food
+	filter := bson.M{"food_id": foodId, "restaurant_id": bson.M{"$in": catalogRestaurantIDs(restaurantId)}}
+	if err := foodCollection.FindOne(ctx, filter).Decode(&food); err != nil {
+		return food, err
+	}
+
+	if food.Restaurant_id == restaurantId {
+		return food, nil
+	}
+
+	var override models.FoodOverride
+	err := foodOverrideCollection.FindOne(ctx, bson.M{"food_id": foodId, "restaurant_id": restaurantId}).Decode(&override)
+	if err == mongo.ErrNoDocuments {
+		return food, nil
+	}
+	if err != nil {
+		return food, err
+	}
+
+	if override.Price != nil {
+		food.Price = override.Price
+	}
+	if override.Available != nil {
+		food.Available = override.Available
+	}
+	if override.Food_image != nil {
+		food.Food_image = override.Food_image
+	}
+	return food, nil
+}
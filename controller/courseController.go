@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"resturnat-management/helper"
+	"resturnat-management/models"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// items ordered without a course, or before courses existed, are part of the first one
+func courseOf(orderItem models.OrderItem) int {
+	if orderItem.Course < 1 {
+		return 1
+	}
+	return orderItem.Course
+}
+
+// courseFilter matches the items of an order's course
+func courseFilter(restaurantId, orderId string, course int) bson.M {
+	filter := bson.M{"order_id": orderId, "restaurant_id": restaurantId, "course": course}
+	if course == 1 {
+		filter["course"] = bson.M{"$in": bson.A{nil, 0, 1}}
+	}
+	return filter
+}
+
+// FireCourse sends a held course of an order to the kitchen
+func FireCourse() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orderId := c.Param("order_id")
+		course, err := strconv.Atoi(c.Param("course"))
+		if err != nil || course < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "course must be a number from 1"})
+			return
+		}
+
+		var order models.Order
+		if err := orderCollection.FindOne(ctx, tenantFilter(c, bson.M{"order_id": orderId})).Decode(&order); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+			return
+		}
+		if models.IsFinalOrderStatus(order.CurrentStatus()) {
+			c.JSON(http.StatusConflict, gin.H{"error": "a " + order.CurrentStatus() + " order can no longer be fired"})
+			return
+		}
+		if order.CurrentStatus() == models.OrderScheduled {
+			c.JSON(http.StatusConflict, gin.H{"error": "a pre-order is fired when it is placed"})
+			return
+		}
+
+		filter := courseFilter(restaurantID(c), orderId, course)
+		filter["fired_at"] = nil
+		filter["kitchen_status"] = bson.M{"$ne": models.KitchenVoided}
+
+		var heldItems []models.OrderItem
+		cursor, err := orderItemCollection.Find(ctx, filter)
+		if err == nil {
+			err = cursor.All(ctx, &heldItems)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the course"})
+			return
+		}
+		if len(heldItems) == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("course %d has nothing held to fire", course)})
+			return
+		}
+
+		fired := []models.OrderItem{}
+		for _, orderItem := range heldItems {
+			after, ok, err := fireOrderItem(ctx, orderItem)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "the course was only partly fired, try again"})
+				return
+			}
+			if !ok {
+				continue
+			}
+			recordAudit(ctx, c, "orderItem", orderItem.OrderItem_id, AuditUpdate, orderItem, after)
+			publishItemEvent(ctx, helper.KitchenItemCreated, after)
+			fired = append(fired, after)
+		}
+		c.JSON(http.StatusOK, gin.H{"order_id": orderId, "course": course, "fired": fired})
+	}
+}
+
+// fireOrderItem queues a held item. ok is false when someone else fired it first
+func fireOrderItem(ctx context.Context, orderItem models.OrderItem) (after models.OrderItem, ok bool, err error) {
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	filter := bson.M{"order_item_id": orderItem.OrderItem_id, "restaurant_id": orderItem.Restaurant_id}
+	guard := bson.M{"order_item_id": orderItem.OrderItem_id, "restaurant_id": orderItem.Restaurant_id, "fired_at": nil}
+
+	err = writeWithEvents(ctx, func(sc mongo.SessionContext) ([]models.OutboxEvent, error) {
+		// a transaction that is retried runs this again
+		ok = false
+		result, err := orderItemCollection.UpdateOne(sc, guard, bson.M{"$set": bson.M{
+			"fired_at":   now,
+			"queued_at":  now,
+			"updated_at": now,
+		}})
+		if err != nil || result.ModifiedCount == 0 {
+			return nil, err
+		}
+		if err := orderItemCollection.FindOne(sc, filter).Decode(&after); err != nil {
+			return nil, err
+		}
+		ok = true
+		return []models.OutboxEvent{orderItemEvent(models.EventOrderItemFired, after)}, nil
+	})
+	return after, ok && err == nil, err
+}
@@ -11,7 +11,7 @@ import (
 	// "resturnat-management/config"
 	"resturnat-management/database"
 	"resturnat-management/models"
-	"strconv"
+	"strings"
 	"time"
 
 	config "resturnat-management/config"
@@ -32,22 +32,14 @@ func GetAllFoods() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		// Retrieve pagination params as before
-		recordPerPage, err := strconv.Atoi(c.Query("recordPerPage"))
-		if err != nil || recordPerPage < 1 {
-			recordPerPage = 10
-		}
-		page, err := strconv.Atoi(c.Query("page"))
-		if err != nil || page < 1 {
-			page = 1
-		}
-		startIndex := (page - 1) * recordPerPage
-		if s := c.Query("startIndex"); s != "" {
-			startIndex, _ = strconv.Atoi(s)
+		query, err := parsePageQuery(c, []string{"created_at", "name"}, "created_at")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 
 		// Create a unique cache key for this query
-		cacheKey := fmt.Sprintf("foods:page=%d:perPage=%d:startIndex=%d", page, recordPerPage, startIndex)
+		cacheKey := fmt.Sprintf("foods:v%d:%s:%s", foodCacheVersion(ctx), restaurantID(c), c.Request.URL.Query().Encode())
 
 		// Check Redis cache
 		cached, err := config.RDB.Get(ctx, cacheKey).Result()
@@ -61,51 +53,43 @@ func GetAllFoods() gin.HandlerFunc {
 			// If unmarshal fails, continue to fetch from DB
 		}
 
-		// Cache miss - fetch from MongoDB
-		matchStage := bson.D{{Key: "$match", Value: bson.D{}}}
-		groupStage := bson.D{
-			{Key: "$group", Value: bson.D{
-				{Key: "_id", Value: nil},
-				{Key: "total_count", Value: bson.D{{Key: "$sum", Value: 1}}},
-				{Key: "data", Value: bson.D{{Key: "$push", Value: "$$ROOT"}}},
-			}},
-		}
-		projectStage := bson.D{
-			{Key: "$project", Value: bson.D{
-				{Key: "_id", Value: 0},
-				{Key: "total_count", Value: 1},
-				{Key: "food_items", Value: bson.D{
-					{Key: "$slice", Value: []interface{}{"$data", startIndex, recordPerPage}},
-				}},
-			}},
-		}
-
-		result, err := foodCollection.Aggregate(ctx, mongo.Pipeline{
-			matchStage, groupStage, projectStage,
-		})
+		// Cache miss - fetch the page from MongoDB, branches also get the head office foods.
+		// overrides are only looked up for the foods on the page
+		filter := catalogFilter(c, bson.M{})
+		total, err := foodCollection.CountDocuments(ctx, filter)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing food items"})
 			return
 		}
+		pipeline := mongo.Pipeline{{{Key: "$match", Value: filter}}}
+		pipeline = append(pipeline, query.stages()...)
+		pipeline = append(pipeline, foodOverrideStages(restaurantID(c), "")...)
 
-		var allFoods []bson.M
-		if err = result.All(ctx, &allFoods); err != nil {
-			log.Fatal(err)
+		result, err := foodCollection.Aggregate(ctx, pipeline)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing food items"})
+			return
 		}
-		if len(allFoods) == 0 {
-			response := bson.M{"total_count": 0, "food_items": []interface{}{}}
-			// Cache empty result with shorter TTL
-			jsonData, _ := json.Marshal(response)
-			config.RDB.Set(ctx, cacheKey, jsonData, 1*time.Minute)
-			c.JSON(http.StatusOK, response)
+		var foods []bson.Raw
+		if err = result.All(ctx, &foods); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing food items"})
+			return
+		}
+		page, err := pageEnvelope[bson.M](foods, total, query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing food items"})
 			return
 		}
 
-		// Cache the result with TTL
-		jsonData, _ := json.Marshal(allFoods[0])
-		config.RDB.Set(ctx, cacheKey, jsonData, 5*time.Minute)
+		// Cache the result with TTL, an empty page only briefly
+		ttl := 5 * time.Minute
+		if total == 0 {
+			ttl = 1 * time.Minute
+		}
+		jsonData, _ := json.Marshal(page)
+		config.RDB.Set(ctx, cacheKey, jsonData, ttl)
 
-		c.JSON(http.StatusOK, allFoods[0])
+		c.JSON(http.StatusOK, page)
 	}
 }
 
@@ -114,8 +98,8 @@ func GetFood() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		foodId := c.Param("food_id")
-		cacheKey := fmt.Sprintf("food:%s", foodId)
+		foodId := c.Param("id")
+		cacheKey := fmt.Sprintf("food:v%d:%s:%s", foodCacheVersion(ctx), restaurantID(c), foodId)
 
 		// Try to get cached food
 		cached, err := config.RDB.Get(ctx, cacheKey).Result()
@@ -130,8 +114,7 @@ func GetFood() gin.HandlerFunc {
 		}
 
 		// Cache miss - fetch from MongoDB
-		var food models.Food
-		err = foodCollection.FindOne(ctx, bson.M{"food_id": foodId}).Decode(&food)
+		food, err := findEffectiveFood(ctx, restaurantID(c), foodId)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while fetching the food item"})
 			return
@@ -165,8 +148,22 @@ func CreateFood() gin.HandlerFunc {
 			return
 		}
 
+		variants, err := prepareVariants(food.Variants)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		food.Variants = variants
+
+		modifierGroups, err := prepareModifierGroups(food.Modifier_groups)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		food.Modifier_groups = modifierGroups
+
 		// finding if the nemu exist or not
-		err := menuCollection.FindOne(ctx, bson.M{"menu_id": food.Menu_id}).Decode(&menu)
+		err = menuCollection.FindOne(ctx, catalogFilter(c, bson.M{"menu_id": food.Menu_id})).Decode(&menu)
 		defer cancel()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "menu did not found"})
@@ -175,7 +172,9 @@ func CreateFood() gin.HandlerFunc {
 
 		food.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		food.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		food.Food_id = primitive.NewObjectID().Hex()
+		food.ID = primitive.NewObjectID()
+		food.Food_id = food.ID.Hex()
+		food.Restaurant_id = restaurantID(c)
 		var num = toFixed(*food.Price, 2)
 		food.Price = &num
 
@@ -184,10 +183,83 @@ func CreateFood() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "food item was not created"})
 			return
 		}
+		recordAudit(ctx, c, "food", food.Food_id, AuditCreate, nil, food)
+		invalidateFoodCache(ctx)
 		c.JSON(http.StatusOK, result)
 
 	}
 }
+
+// prepareVariants gives new variants an id and rounds their prices. variant names must be
+// unique within a food since waiters pick a size by name
+func prepareVariants(variants []models.FoodVariant) ([]models.FoodVariant, error) {
+	prepared := []models.FoodVariant{}
+	names := map[string]bool{}
+	for _, variant := range variants {
+		name := strings.ToLower(strings.TrimSpace(*variant.Name))
+		if names[name] {
+			return nil, fmt.Errorf("variant %s is listed twice", *variant.Name)
+		}
+		names[name] = true
+
+		if variant.Variant_id == "" {
+			variant.Variant_id = primitive.NewObjectID().Hex()
+		}
+		var num = toFixed(*variant.Price, 2)
+		variant.Price = &num
+		prepared = append(prepared, variant)
+	}
+	return prepared, nil
+}
+
+// findVariant picks the variant an order item refers to. foods with variants can only be
+// ordered as one of them, foods without can't be given one
+func findVariant(food models.Food, variantId *string) (*models.FoodVariant, error) {
+	if len(food.Variants) == 0 {
+		if variantId != nil {
+			return nil, fmt.Errorf("%s has no variants", *food.Name)
+		}
+		return nil, nil
+	}
+	if variantId == nil {
+		return nil, fmt.Errorf("pick a variant of %s", *food.Name)
+	}
+	for _, variant := range food.Variants {
+		if variant.Variant_id != *variantId {
+			continue
+		}
+		if variant.Available != nil && !*variant.Available {
+			return nil, fmt.Errorf("%s %s is not available", *variant.Name, *food.Name)
+		}
+		return &variant, nil
+	}
+	return nil, fmt.Errorf("variant not found for %s", *food.Name)
+}
+
+// catalogPrice is what the menu charges for a food, or for the chosen variant of it
+func catalogPrice(food models.Food, variant *models.FoodVariant) float64 {
+	if variant != nil {
+		return toFixed(*variant.Price, 2)
+	}
+	if food.Price == nil {
+		return 0
+	}
+	return toFixed(*food.Price, 2)
+}
+
+// cached food listings and items embed this version in their keys, bumping it makes
+// every cached entry stale at once so head office edits reach all branches
+func foodCacheVersion(ctx context.Context) int64 {
+	version, _ := config.RDB.Get(ctx, "foods:version").Int64()
+	return version
+}
+
+func invalidateFoodCache(ctx context.Context) {
+	if err := config.RDB.Incr(ctx, "foods:version").Err(); err != nil {
+		log.Printf("Failed to invalidate the food cache: %v", err)
+	}
+}
+
 func round(num float64) int {
 	return int(num + 0.5)
 }
@@ -204,13 +276,21 @@ func UpdateFood() gin.HandlerFunc {
 		var food models.Food
 		defer cancel()
 
-		foodId := c.Param("food_id")
+		foodId := c.Param("id")
 
 		if err := c.BindJSON((&food)); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
+		// branches can't edit the head office's foods, they override them instead
+		var existing models.Food
+		err := foodCollection.FindOne(ctx, catalogFilter(c, bson.M{"food_id": foodId})).Decode(&existing)
+		if err == nil && existing.Restaurant_id != restaurantID(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "this food belongs to the head office, use PATCH /food/:id/override instead"})
+			return
+		}
+
 		var updateObj primitive.D
 
 		if food.Name != nil {
@@ -222,8 +302,62 @@ func UpdateFood() gin.HandlerFunc {
 		if food.Food_image != nil {
 			updateObj = append(updateObj, bson.E{Key: "food_image", Value: food.Food_image})
 		}
+		if food.Available != nil {
+			updateObj = append(updateObj, bson.E{Key: "available", Value: food.Available})
+		}
+		if food.Tax_rate != nil {
+			if *food.Tax_rate < 0 || *food.Tax_rate > 100 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "tax_rate must be between 0 and 100"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "tax_rate", Value: food.Tax_rate})
+		}
+		if food.Prep_minutes != nil {
+			if *food.Prep_minutes < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "prep_minutes must be at least 1"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "prep_minutes", Value: food.Prep_minutes})
+		}
+		if food.Station != nil {
+			if !models.IsValidStation(*food.Station) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "station must be one of grill, fryer, bar or dessert"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "station", Value: food.Station})
+		}
+		// variants are replaced as a whole, send the ids of the ones to keep
+		if food.Variants != nil {
+			for _, variant := range food.Variants {
+				if validationErr := validate.Struct(variant); validationErr != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+					return
+				}
+			}
+			variants, err := prepareVariants(food.Variants)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "variants", Value: variants})
+		}
+		// modifier groups are replaced as a whole too
+		if food.Modifier_groups != nil {
+			for _, group := range food.Modifier_groups {
+				if validationErr := validate.Struct(group); validationErr != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+					return
+				}
+			}
+			modifierGroups, err := prepareModifierGroups(food.Modifier_groups)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "modifier_groups", Value: modifierGroups})
+		}
 		if food.Menu_id != nil {
-			err := menuCollection.FindOne(ctx, bson.M{"menu_id": food.Menu_id}).Decode(&menu)
+			err := menuCollection.FindOne(ctx, catalogFilter(c, bson.M{"menu_id": food.Menu_id})).Decode(&menu)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "menu not found"})
 				defer cancel()
@@ -236,12 +370,13 @@ func UpdateFood() gin.HandlerFunc {
 
 		upsert := true
 
-		filter := bson.M{"food_id": foodId}
+		filter := tenantFilter(c, bson.M{"food_id": foodId})
 
 		opt := options.UpdateOptions{
 			Upsert: &upsert,
 		}
 
+		before := snapshot(ctx, foodCollection, filter)
 		result, err := foodCollection.UpdateOne(ctx, filter, bson.D{
 			{Key: "$set", Value: updateObj},
 		}, &opt,
@@ -251,6 +386,8 @@ func UpdateFood() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
+		recordAudit(ctx, c, "food", foodId, AuditUpdate, before, snapshot(ctx, foodCollection, filter))
+		invalidateFoodCache(ctx)
 		defer cancel()
 		c.JSON(http.StatusOK, gin.H{"message": "food item updated successfully", "data": result})
 
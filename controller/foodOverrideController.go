@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"resturnat-management/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UpdateFoodOverride lets a branch change the price, availability or image of a head
+// office food. only the fields sent are overridden, the rest keep following the head office
+func UpdateFoodOverride() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		foodId := c.Param("id")
+		var override models.FoodOverride
+		var food models.Food
+
+		if err := c.BindJSON(&override); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(override)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		err := foodCollection.FindOne(ctx, catalogFilter(c, bson.M{"food_id": foodId})).Decode(&food)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "food not found"})
+			return
+		}
+		if food.Restaurant_id == restaurantID(c) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "only head office foods can be overridden, update the food directly"})
+			return
+		}
+		// variants carry their own prices, a branch price would never be charged
+		if override.Price != nil && len(food.Variants) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "food has variants, its price is set per variant by the head office"})
+			return
+		}
+
+		var updateObj primitive.D
+		if override.Price != nil {
+			var num = toFixed(*override.Price, 2)
+			updateObj = append(updateObj, bson.E{Key: "price", Value: num})
+		}
+		if override.Available != nil {
+			updateObj = append(updateObj, bson.E{Key: "available", Value: override.Available})
+		}
+		if override.Food_image != nil {
+			updateObj = append(updateObj, bson.E{Key: "food_image", Value: override.Food_image})
+		}
+		if len(updateObj) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "nothing to override, send price, available or food_image"})
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: now})
+
+		upsert := true
+		opt := options.UpdateOptions{
+			Upsert: &upsert,
+		}
+
+		filter := tenantFilter(c, bson.M{"food_id": foodId})
+		before := snapshot(ctx, foodOverrideCollection, filter)
+		result, err := foodOverrideCollection.UpdateOne(ctx,
+			filter,
+			bson.D{
+				{Key: "$set", Value: updateObj},
+				{Key: "$setOnInsert", Value: bson.D{
+					{Key: "_id", Value: primitive.NewObjectID()},
+					{Key: "created_at", Value: now},
+				}},
+			},
+			&opt,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "food override was not saved"})
+			return
+		}
+		action := AuditUpdate
+		if before == nil {
+			action = AuditCreate
+		}
+		recordAudit(ctx, c, "foodOverride", foodId, action, before, snapshot(ctx, foodOverrideCollection, filter))
+		invalidateFoodCache(ctx)
+		c.JSON(http.StatusOK, gin.H{"message": "food override saved successfully", "data": result})
+	}
+}
+
+// DeleteFoodOverride drops every override the branch has for a food so it follows the head office again
+func DeleteFoodOverride() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		foodId := c.Param("id")
+
+		filter := tenantFilter(c, bson.M{"food_id": foodId})
+		before := snapshot(ctx, foodOverrideCollection, filter)
+		result, err := foodOverrideCollection.DeleteOne(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "food override was not removed"})
+			return
+		}
+		if result.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "this branch has no override for the food"})
+			return
+		}
+		recordAudit(ctx, c, "foodOverride", foodId, AuditDelete, before, nil)
+		invalidateFoodCache(ctx)
+		c.JSON(http.StatusOK, gin.H{"message": "food override removed, the head office values apply again"})
+	}
+}
@@ -2,17 +2,17 @@ package controller
 
 import (
 	"context"
-	"log"
+	"errors"
 	"net/http"
 	"resturnat-management/database"
 	"resturnat-management/models"
+	"resturnat-management/pricing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type InvoiceViewFormat struct {
@@ -21,6 +21,7 @@ type InvoiceViewFormat struct {
 	Order_id         string
 	Payment_status   *string
 	Payment_due      interface{}
+	Totals           pricing.Breakdown
 	Table_number     interface{}
 	Payment_due_date time.Time
 	Order_details    interface{}
@@ -38,22 +39,31 @@ func CreateInvoice() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		var order models.Order
-		err := orderCollection.FindOne(ctx, bson.M{"order_id": invoice.Order_id}).Decode(&order)
-		defer cancel()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "order was not found"})
+		// cancelled, merged and paid orders take no new invoices
+		if _, status, err := openOrder(ctx, c, invoice.Order_id); err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
 		}
 
+		// invoices are paid through UpdateInvoice, which is what emits invoice.paid
 		status := "PENDING"
-		if invoice.Payment_status == nil {
-			invoice.Payment_status = &status
+		if invoice.Payment_status != nil && *invoice.Payment_status != status {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "new invoices are PENDING, update the invoice once it is paid"})
+			return
 		}
+		invoice.Payment_status = &status
 		invoice.Payment_due_date, _ = time.Parse(time.RFC3339, time.Now().AddDate(0, 0, 1).Format(time.RFC3339))
 		invoice.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		invoice.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		invoice.ID = primitive.NewObjectID()
 		invoice.Invoice_id = invoice.ID.Hex()
+		invoice.Restaurant_id = restaurantID(c)
+		totals, err := orderBreakdown(ctx, restaurantID(c), invoice.Order_id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while pricing the order"})
+			return
+		}
+		invoice.Totals = &totals
 
 		validationErr := validate.Struct(invoice)
 		if validationErr != nil {
@@ -61,11 +71,17 @@ func CreateInvoice() gin.HandlerFunc {
 			return
 		}
 
-		result, err := invoiceColletion.InsertOne(ctx, invoice)
+		var result *mongo.InsertOneResult
+		err = writeWithEvents(ctx, func(sc mongo.SessionContext) ([]models.OutboxEvent, error) {
+			var err error
+			result, err = invoiceColletion.InsertOne(sc, invoice)
+			return []models.OutboxEvent{invoiceEvent(models.EventInvoiceCreated, invoice)}, err
+		})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "invoice item was not created"})
 			return
 		}
+		recordAudit(ctx, c, "invoice", invoice.Invoice_id, AuditCreate, nil, invoice)
 		defer cancel()
 		c.JSON(http.StatusOK, result)
 
@@ -75,36 +91,37 @@ func CreateInvoice() gin.HandlerFunc {
 func GetInvoices() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-
-		result, err := invoiceColletion.Find(context.TODO(), bson.M{})
 		defer cancel()
+
+		query, err := parsePageQuery(c, []string{"created_at", "payment_due_date"}, "-created_at")
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing invoice items"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
-
-		var allInvoice []bson.M
-		if err = result.All(ctx, &allInvoice); err != nil {
-			log.Fatal(err)
+		page, err := findPage[bson.M](ctx, invoiceColletion, tenantFilter(c, bson.M{}), query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing invoice items"})
+			return
 		}
-		c.JSON(http.StatusOK, allInvoice)
-
+		c.JSON(http.StatusOK, page)
 	}
 }
 func GetAllInvoice() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		invoiceId := c.Param("invoice_id")
+		invoiceId := c.Param("id")
 
 		var invoice models.Invoice
-		err := invoiceColletion.FindOne(ctx, bson.M{"invoice_id": invoiceId}).Decode(&invoice)
+		err := invoiceColletion.FindOne(ctx, tenantFilter(c, bson.M{"invoice_id": invoiceId})).Decode(&invoice)
 		defer cancel()
 
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the invoice item"})
+			return
 		}
 		var invoiceView InvoiceViewFormat
 
-		allOrderItems, err := ItemsByOrder(invoice.Order_id)
+		allOrderItems, err := ItemsByOrder(restaurantID(c), invoice.Order_id)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the order items"})
 			return
@@ -119,15 +136,32 @@ func GetAllInvoice() gin.HandlerFunc {
 
 		invoiceView.Invoice_id = invoice.Invoice_id
 		invoiceView.Payment_status = invoice.Payment_status
-		invoiceView.Payment_due = allOrderItems[0]["payment_due"]
-		invoiceView.Table_number = allOrderItems[0]["table_number"]
-		invoiceView.Order_details = allOrderItems[0]["order_items"]
+
+		// what is due is what the invoice was priced at, only invoices saved before
+		// totals were stored are priced again from the order
+		if invoice.Totals != nil {
+			invoiceView.Totals = *invoice.Totals
+		} else {
+			totals, err := orderBreakdown(ctx, restaurantID(c), invoice.Order_id)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while pricing the order"})
+				return
+			}
+			invoiceView.Totals = totals
+		}
+		invoiceView.Payment_due = invoiceView.Totals.Grand_total
+		if len(allOrderItems) > 0 {
+			invoiceView.Table_number = allOrderItems[0]["table_number"]
+			invoiceView.Order_details = allOrderItems[0]["order_items"]
+		}
 
 		c.JSON(http.StatusOK, invoiceView)
 
 	}
 }
 
+var errInvoiceNotFound = errors.New("invoice not found")
+
 func UpdateInvoice() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
@@ -139,43 +173,83 @@ func UpdateInvoice() gin.HandlerFunc {
 			return
 		}
 
-		invoiceID := c.Param("invoice_id")
+		invoiceID := c.Param("id")
 		var updateObj primitive.D
 
 		if invoice.Payment_method != nil {
+			if err := validate.Var(*invoice.Payment_method, "eq=CARD|eq=CASH|eq="); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "payment_method must be CARD or CASH"})
+				return
+			}
 			updateObj = append(updateObj, bson.E{Key: "payment_method", Value: invoice.Payment_method})
 		}
+		// VOIDED is only ever set by merging the invoice's order into another one
 		if invoice.Payment_status != nil {
+			if err := validate.Var(*invoice.Payment_status, "eq=PENDING|eq=COMPLETED|eq=FAILED"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "payment_status must be PENDING, COMPLETED or FAILED"})
+				return
+			}
 			updateObj = append(updateObj, bson.E{Key: "payment_status", Value: invoice.Payment_status})
 		}
 
 		invoice.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: invoice.Updated_at})
 
-		upsert := true
-
-		filter := bson.M{"invoice_id": invoiceID}
+		filter := tenantFilter(c, bson.M{"invoice_id": invoiceID})
+		before := snapshot(ctx, invoiceColletion, filter)
+		if before == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invoice not found"})
+			return
+		}
+		if before["payment_status"] == "VOIDED" {
+			c.JSON(http.StatusConflict, gin.H{"error": "the invoice was voided when its order was merged into another one"})
+			return
+		}
 
-		opt := options.UpdateOptions{
-			Upsert: &upsert,
+		var result *mongo.UpdateResult
+		var updated models.Invoice
+		err := writeWithEvents(ctx, func(sc mongo.SessionContext) ([]models.OutboxEvent, error) {
+			// the update that moves the invoice to COMPLETED is the one that paid it, and only
+			// one request can make that move, so invoice.paid goes out once
+			eventType := models.EventInvoiceUpdated
+			var err error
+			if invoicePaid(invoice) {
+				unpaid := tenantFilter(c, bson.M{"invoice_id": invoiceID, "payment_status": bson.M{"$nin": bson.A{"COMPLETED", "VOIDED"}}})
+				if result, err = invoiceColletion.UpdateOne(sc, unpaid, bson.D{{Key: "$set", Value: updateObj}}); err != nil {
+					return nil, err
+				}
+				if result.ModifiedCount == 1 {
+					eventType = models.EventInvoicePaid
+				}
+			}
+			if eventType != models.EventInvoicePaid {
+				open := tenantFilter(c, bson.M{"invoice_id": invoiceID, "payment_status": bson.M{"$ne": "VOIDED"}})
+				if result, err = invoiceColletion.UpdateOne(sc, open, bson.D{{Key: "$set", Value: updateObj}}); err != nil {
+					return nil, err
+				}
+				if result.MatchedCount == 0 {
+					return nil, errInvoiceNotFound
+				}
+			}
+			if err := invoiceColletion.FindOne(sc, filter).Decode(&updated); err != nil {
+				return nil, err
+			}
+			return []models.OutboxEvent{invoiceEvent(eventType, updated)}, nil
+		})
+		if errors.Is(err, errInvoiceNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
 		}
-		status := "PENDING"
-		if invoice.Payment_status == nil {
-			invoice.Payment_status = &status
-		}
-
-		result, err := invoiceColletion.UpdateOne(
-			ctx,
-			filter,
-			bson.D{
-				{Key: "$set", Value: updateObj},
-			},
-			&opt,
-		)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "invoice update failed"})
+			return
 		}
+		recordAudit(ctx, c, "invoice", invoiceID, AuditUpdate, before, updated)
 		defer cancel()
 		c.JSON(http.StatusOK, result)
 	}
 }
+
+func invoicePaid(invoice models.Invoice) bool {
+	return invoice.Payment_status != nil && *invoice.Payment_status == "COMPLETED"
+}
@@ -0,0 +1,600 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"resturnat-management/helper"
+	"resturnat-management/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// KitchenTicket is what the kitchen needs to cook an order, without any prices
+type KitchenTicket struct {
+	Order_id     string       `json:"order_id"`
+	Order_type   string       `json:"order_type"`
+	Table_number *int         `json:"table_number"`
+	Promised_at  *time.Time   `json:"promised_at"`
+	Order_status string       `json:"order_status"`
+	Placed_at    time.Time    `json:"placed_at"`
+	Items        []TicketItem `json:"items"`
+	Notes        []string     `json:"notes"`
+}
+
+type TicketItem struct {
+	Order_item_id  string     `json:"order_item_id"`
+	Order_id       string     `json:"order_id"`
+	Table_number   *int       `json:"table_number,omitempty"`
+	Station        *string    `json:"station"`
+	Kitchen_status string     `json:"kitchen_status"`
+	Queued_at      *time.Time `json:"queued_at"`
+	Late           bool       `json:"late"`
+	Course         int        `json:"course"`
+	Held           bool       `json:"held"`
+	Food_name      string     `json:"food_name"`
+	Variant_name   *string    `json:"variant_name"`
+	Quantity       int        `json:"quantity"`
+	Portion        *string    `json:"portion"`
+	Modifiers      []string   `json:"modifiers"`
+}
+
+func GetKitchenTicket() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orderId := c.Param("order_id")
+
+		var order models.Order
+		if err := orderCollection.FindOne(ctx, tenantFilter(c, bson.M{"order_id": orderId})).Decode(&order); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+			return
+		}
+
+		ticket := KitchenTicket{
+			Order_id:     order.Order_id,
+			Order_type:   order.CurrentType(),
+			Promised_at:  order.Promised_at,
+			Order_status: order.CurrentStatus(),
+			Placed_at:    order.Created_at,
+			Items:        []TicketItem{},
+			Notes:        []string{},
+		}
+
+		if order.Table_id != nil {
+			var table models.Table
+			if err := tableCollection.FindOne(ctx, tenantFilter(c, bson.M{"table_id": order.Table_id})).Decode(&table); err == nil {
+				ticket.Table_number = table.Table_number
+			}
+		}
+
+		var orderItems []models.OrderItem
+		cursor, err := orderItemCollection.Find(ctx, tenantFilter(c, bson.M{"order_id": orderId}))
+		if err == nil {
+			err = cursor.All(ctx, &orderItems)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the order items"})
+			return
+		}
+
+		foodNames, err := foodNamesOf(ctx, c, orderItems)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the foods"})
+			return
+		}
+		for _, orderItem := range orderItems {
+			ticket.Items = append(ticket.Items, ticketItem(orderItem, foodNames))
+		}
+
+		var notes []models.Note
+		cursor, err = noteCollection.Find(ctx, tenantFilter(c, bson.M{"order_id": orderId}))
+		if err == nil {
+			err = cursor.All(ctx, &notes)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the notes"})
+			return
+		}
+		for _, note := range notes {
+			ticket.Notes = append(ticket.Notes, note.Title+": "+note.Text)
+		}
+
+		c.JSON(http.StatusOK, ticket)
+	}
+}
+
+// foodNamesOf maps the food ids of order items to the foods' names
+func foodNamesOf(ctx context.Context, c *gin.Context, orderItems []models.OrderItem) (map[string]string, error) {
+	foodIds := bson.A{}
+	for _, orderItem := range orderItems {
+		if orderItem.Food_id != nil {
+			foodIds = append(foodIds, *orderItem.Food_id)
+		}
+	}
+	return foodNames(ctx, c, foodIds)
+}
+
+func foodNames(ctx context.Context, c *gin.Context, foodIds bson.A) (map[string]string, error) {
+	names := map[string]string{}
+	if len(foodIds) == 0 {
+		return names, nil
+	}
+	cursor, err := foodCollection.Find(ctx, catalogFilter(c, bson.M{"food_id": bson.M{"$in": foodIds}}))
+	if err != nil {
+		return nil, err
+	}
+	var foods []models.Food
+	if err := cursor.All(ctx, &foods); err != nil {
+		return nil, err
+	}
+	for _, food := range foods {
+		if food.Name != nil {
+			names[food.Food_id] = *food.Name
+		}
+	}
+	return names, nil
+}
+
+func ticketItem(orderItem models.OrderItem, foodNames map[string]string) TicketItem {
+	item := TicketItem{
+		Order_item_id:  orderItem.OrderItem_id,
+		Order_id:       orderItem.Order_id,
+		Station:        orderItem.Station,
+		Kitchen_status: orderItem.CurrentKitchenStatus(),
+		Queued_at:      orderItem.Queued_at,
+		Late:           orderItem.Late_alerted_at != nil,
+		Course:         courseOf(orderItem),
+		Held:           orderItem.Fired_at == nil,
+		Variant_name:   orderItem.Variant_name,
+		Quantity:       1,
+		Portion:        orderItem.Portion,
+		Modifiers:      []string{},
+	}
+	if orderItem.Food_id != nil {
+		item.Food_name = foodNames[*orderItem.Food_id]
+	}
+	if orderItem.Quantity != nil {
+		item.Quantity = *orderItem.Quantity
+	}
+	for _, modifier := range orderItem.Modifiers {
+		item.Modifiers = append(item.Modifiers, modifier.Group_name+": "+modifier.Name)
+	}
+	return item
+}
+
+// displays get a comment line this often so proxies don't close an idle stream
+const kitchenHeartbeat = 15 * time.Second
+
+// publishItemEvent tells the kitchen displays about an order item. a failure is only logged,
+// the item is saved either way and a display can always reload
+func publishItemEvent(ctx context.Context, eventType string, orderItem models.OrderItem) {
+	// the kitchen doesn't see held courses, fired items arrive as created
+	if orderItem.Fired_at == nil {
+		return
+	}
+	station := ""
+	if orderItem.Station != nil {
+		station = *orderItem.Station
+	}
+	_, err := helper.PublishKitchenEvent(ctx, helper.KitchenEvent{
+		Type:          eventType,
+		Restaurant_id: orderItem.Restaurant_id,
+		Order_id:      orderItem.Order_id,
+		Order_item_id: orderItem.OrderItem_id,
+		Station:       station,
+		Item:          orderItem,
+	})
+	if err != nil {
+		log.Printf("kitchen event %s for order item %s was not published: %v", eventType, orderItem.OrderItem_id, err)
+	}
+}
+
+// StreamKitchenEvents is a server-sent events feed of the restaurant's order items, optionally
+// only those of one station. a display that reconnects with Last-Event-ID (or last_event_id)
+// first gets the events it missed, and a reset event if some of them are no longer kept
+func StreamKitchenEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		restaurantId := restaurantID(c)
+		station := c.Query("station")
+		lastId := c.GetHeader("Last-Event-ID")
+		if lastId == "" {
+			lastId = c.Query("last_event_id")
+		}
+		sent := lastId
+
+		// a display without a Last-Event-ID only gets what happens from now on
+		if !helper.IsStreamId(lastId) {
+			latest, err := helper.LatestKitchenEventId(ctx, restaurantId)
+			if err != nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "the kitchen feed is not available"})
+				return
+			}
+			lastId = ""
+			sent = latest
+		}
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+
+		if lastId != "" {
+			events, complete, err := helper.KitchenEventsSince(ctx, restaurantId, lastId)
+			if err != nil {
+				writeKitchenEvent(c, "", "error", gin.H{"error": "missed events could not be replayed"})
+				return
+			}
+			if !complete {
+				writeKitchenEvent(c, "", "reset", gin.H{"reason": "some missed events are no longer kept, reload the orders"})
+			}
+			for _, event := range events {
+				sent = event.Id
+				if station == "" || event.Station == station {
+					writeKitchenEvent(c, event.Id, event.Type, event)
+				}
+			}
+		}
+		c.Writer.Flush()
+
+		// new events are read off the stream from the last one sent, so the feed keeps the
+		// stream's order whichever api instance added them. a read that waited a whole
+		// heartbeat for nothing sends a ping instead
+		for ctx.Err() == nil {
+			events, err := helper.ReadKitchenEvents(ctx, restaurantId, sent, kitchenHeartbeat)
+			if err != nil {
+				if ctx.Err() == nil {
+					writeKitchenEvent(c, "", "error", gin.H{"error": "the kitchen feed was interrupted, reconnect"})
+				}
+				return
+			}
+			if len(events) == 0 {
+				fmt.Fprint(c.Writer, ": ping\n\n")
+				c.Writer.Flush()
+				continue
+			}
+			for _, event := range events {
+				sent = event.Id
+				if station == "" || event.Station == station {
+					writeKitchenEvent(c, event.Id, event.Type, event)
+				}
+			}
+		}
+	}
+}
+
+func writeKitchenEvent(c *gin.Context, id, eventType string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	if id != "" {
+		fmt.Fprintf(c.Writer, "id: %s\n", id)
+	}
+	fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", eventType, payload)
+	c.Writer.Flush()
+}
+
+var (
+	errOrderItemNotFound        = errors.New("order item not found")
+	errIllegalKitchenTransition = errors.New("illegal kitchen status transition")
+	errCourseHeld               = errors.New("the item's course is held, fire it first")
+)
+
+// the field that records when an item reached each kitchen status
+var kitchenTimestampFields = map[string]string{
+	models.KitchenQueued:  "queued_at",
+	models.KitchenCooking: "cooking_at",
+	models.KitchenReady:   "ready_at",
+	models.KitchenServed:  "served_at",
+	models.KitchenVoided:  "voided_at",
+}
+
+// queueOrderItem puts a new item at the start of its way through the kitchen. a held item
+// waits for its course to be fired before it is queued
+func queueOrderItem(orderItem *models.OrderItem, fire bool) {
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	orderItem.Kitchen_status = models.KitchenQueued
+	orderItem.Queued_at = nil
+	orderItem.Fired_at = nil
+	if fire {
+		orderItem.Queued_at = &now
+		orderItem.Fired_at = &now
+	}
+	orderItem.Cooking_at = nil
+	orderItem.Ready_at = nil
+	orderItem.Served_at = nil
+	orderItem.Voided_at = nil
+}
+
+// changeKitchenStatus moves an item along models.KitchenTransitions. like changeOrderStatus the
+// update only matches while the item still has the status it was read with
+func changeKitchenStatus(ctx context.Context, restaurantId, orderItemId, to string) (before, after models.OrderItem, err error) {
+	filter := bson.M{"order_item_id": orderItemId, "restaurant_id": restaurantId}
+	if err = orderItemCollection.FindOne(ctx, filter).Decode(&before); err != nil {
+		return before, after, errOrderItemNotFound
+	}
+
+	from := before.CurrentKitchenStatus()
+	if before.Fired_at == nil {
+		return before, after, errCourseHeld
+	}
+	if !models.CanTransitionKitchen(from, to) {
+		return before, after, fmt.Errorf("%w from %s to %s", errIllegalKitchenTransition, from, to)
+	}
+
+	guard := kitchenStatusGuard(before)
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	update := bson.M{
+		"kitchen_status":           to,
+		kitchenTimestampFields[to]: now,
+		"updated_at":               now,
+	}
+	// the prep time is what the guest waited, from queued until ready
+	if to == models.KitchenReady && before.Queued_at != nil {
+		update["prep_seconds"] = int64(now.Sub(*before.Queued_at).Seconds())
+	}
+	err = writeWithEvents(ctx, func(sc mongo.SessionContext) ([]models.OutboxEvent, error) {
+		result, err := orderItemCollection.UpdateOne(sc, guard, bson.M{"$set": update})
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, errOrderChanged
+		}
+		if err := orderItemCollection.FindOne(sc, filter).Decode(&after); err != nil {
+			return nil, err
+		}
+		return []models.OutboxEvent{orderItemEvent(models.KitchenStatusEvent(to), after)}, nil
+	})
+	return before, after, err
+}
+
+// kitchenStatusGuard matches an order item only while it still has the kitchen status it was read with
+func kitchenStatusGuard(orderItem models.OrderItem) bson.M {
+	guard := bson.M{"order_item_id": orderItem.OrderItem_id, "restaurant_id": orderItem.Restaurant_id, "kitchen_status": orderItem.Kitchen_status}
+	if orderItem.Kitchen_status == "" {
+		guard["kitchen_status"] = bson.M{"$in": bson.A{nil, ""}}
+	}
+	return guard
+}
+
+// UpdateKitchenStatus is how the kitchen moves an item along. the order follows its items:
+// it is preparing once one of them is cooking and ready once all of them are
+func UpdateKitchenStatus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orderItemId := c.Param("id")
+		var body struct {
+			Status string `json:"status"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !models.IsValidKitchenStatus(body.Status) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown kitchen status " + body.Status})
+			return
+		}
+		if body.Status == models.KitchenVoided {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "void an item with POST /orderitem/:id/void, it needs a reason"})
+			return
+		}
+
+		before, after, err := changeKitchenStatus(ctx, restaurantID(c), orderItemId, body.Status)
+		switch {
+		case errors.Is(err, errOrderItemNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, errCourseHeld):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, errIllegalKitchenTransition):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "allowed": models.KitchenTransitions[before.CurrentKitchenStatus()]})
+			return
+		case errors.Is(err, errOrderChanged):
+			c.JSON(http.StatusConflict, gin.H{"error": "the order item was changed by someone else, try again"})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "kitchen status update failed"})
+			return
+		}
+
+		recordAudit(ctx, c, "orderItem", orderItemId, AuditUpdate, before, after)
+		publishItemEvent(ctx, helper.KitchenItemUpdated, after)
+
+		switch after.Kitchen_status {
+		case models.KitchenCooking:
+			advanceOrder(ctx, c, after.Order_id, models.OrderPreparing)
+		case models.KitchenReady:
+			if orderItemsReady(ctx, restaurantID(c), after.Order_id) {
+				advanceOrder(ctx, c, after.Order_id, models.OrderReady)
+			}
+		}
+		c.JSON(http.StatusOK, after)
+	}
+}
+
+// orderItemsReady reports whether every item of an order that is still wanted is ready or
+// served. an order whose items were all voided isn't ready, it has nothing to serve
+func orderItemsReady(ctx context.Context, restaurantId, orderId string) bool {
+	filter := bson.M{"order_id": orderId, "restaurant_id": restaurantId}
+	var orderItems []models.OrderItem
+	cursor, err := orderItemCollection.Find(ctx, filter)
+	if err == nil {
+		err = cursor.All(ctx, &orderItems)
+	}
+	if err != nil {
+		log.Printf("items of order %s could not be checked: %v", orderId, err)
+		return false
+	}
+
+	wanted := 0
+	for _, orderItem := range orderItems {
+		switch orderItem.CurrentKitchenStatus() {
+		case models.KitchenVoided:
+			continue
+		case models.KitchenReady, models.KitchenServed:
+			wanted++
+		default:
+			return false
+		}
+	}
+	return wanted > 0
+}
+
+// advanceOrder moves an order forward to a status one legal transition at a time, each step is
+// audited like a manual one. an order already past the status is left alone
+func advanceOrder(ctx context.Context, c *gin.Context, orderId, to string) {
+	var order models.Order
+	if err := orderCollection.FindOne(ctx, tenantFilter(c, bson.M{"order_id": orderId})).Decode(&order); err != nil {
+		log.Printf("order %s could not be advanced to %s: %v", orderId, to, err)
+		return
+	}
+
+	for _, status := range models.OrderPath(order.CurrentStatus(), to) {
+		before, after, err := changeOrderStatus(ctx, restaurantID(c), orderId, status, c.GetString("uid"), nil)
+		if err != nil {
+			log.Printf("order %s could not be advanced to %s: %v", orderId, status, err)
+			return
+		}
+		recordAudit(ctx, c, "order", orderId, AuditUpdate, before, after)
+	}
+}
+
+// voidCancelledItems voids the items of an order being cancelled that the kitchen hasn't served
+// yet, for the order's reason. it runs in the cancel's transaction and returns the voided items
+// before and after
+func voidCancelledItems(sc mongo.SessionContext, order models.Order, void itemVoid) (before, after []models.OrderItem, err error) {
+	var orderItems []models.OrderItem
+	cursor, err := orderItemCollection.Find(sc, bson.M{"order_id": order.Order_id, "restaurant_id": order.Restaurant_id})
+	if err == nil {
+		err = cursor.All(sc, &orderItems)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, orderItem := range orderItems {
+		status := orderItem.CurrentKitchenStatus()
+		if status == models.KitchenServed || status == models.KitchenVoided {
+			continue
+		}
+		itemBefore, itemAfter, err := voidOrderItem(sc, order.Restaurant_id, orderItem.OrderItem_id, void)
+		if err != nil {
+			return nil, nil, err
+		}
+		before = append(before, itemBefore)
+		after = append(after, itemAfter)
+	}
+	return before, after, nil
+}
+
+// GetStationQueue lists what a station still has to cook or hand over, oldest first
+func GetStationQueue() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		station := c.Query("station")
+		if !models.IsValidStation(station) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "station must be one of grill, fryer, bar or dessert"})
+			return
+		}
+
+		filter := tenantFilter(c, bson.M{
+			"station":        station,
+			"fired_at":       bson.M{"$ne": nil},
+			"kitchen_status": bson.M{"$in": bson.A{nil, "", models.KitchenQueued, models.KitchenCooking, models.KitchenReady}},
+		})
+		opts := options.Find().SetSort(bson.D{{Key: "queued_at", Value: 1}, {Key: "created_at", Value: 1}})
+
+		var orderItems []models.OrderItem
+		cursor, err := orderItemCollection.Find(ctx, filter, opts)
+		if err == nil {
+			err = cursor.All(ctx, &orderItems)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the queue"})
+			return
+		}
+
+		foodNames, err := foodNamesOf(ctx, c, orderItems)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the foods"})
+			return
+		}
+		tableNumbers, err := tableNumbersOf(ctx, c, orderItems)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the tables"})
+			return
+		}
+
+		queue := []TicketItem{}
+		for _, orderItem := range orderItems {
+			item := ticketItem(orderItem, foodNames)
+			item.Table_number = tableNumbers[orderItem.Order_id]
+			queue = append(queue, item)
+		}
+		c.JSON(http.StatusOK, gin.H{"station": station, "items": queue})
+	}
+}
+
+// tableNumbersOf maps the order ids of order items to the number of the order's table
+func tableNumbersOf(ctx context.Context, c *gin.Context, orderItems []models.OrderItem) (map[string]*int, error) {
+	numbers := map[string]*int{}
+	orderIds := bson.A{}
+	for _, orderItem := range orderItems {
+		orderIds = append(orderIds, orderItem.Order_id)
+	}
+	if len(orderIds) == 0 {
+		return numbers, nil
+	}
+
+	var orders []models.Order
+	cursor, err := orderCollection.Find(ctx, tenantFilter(c, bson.M{"order_id": bson.M{"$in": orderIds}}))
+	if err == nil {
+		err = cursor.All(ctx, &orders)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tableIds := bson.A{}
+	for _, order := range orders {
+		if order.Table_id != nil {
+			tableIds = append(tableIds, *order.Table_id)
+		}
+	}
+
+	var tables []models.Table
+	cursor, err = tableCollection.Find(ctx, tenantFilter(c, bson.M{"table_id": bson.M{"$in": tableIds}}))
+	if err == nil {
+		err = cursor.All(ctx, &tables)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tableNumbers := map[string]*int{}
+	for _, table := range tables {
+		tableNumbers[table.Table_id] = table.Table_number
+	}
+	for _, order := range orders {
+		if order.Table_id != nil {
+			numbers[order.Order_id] = tableNumbers[*order.Table_id]
+		}
+	}
+	return numbers, nil
+}
@@ -23,7 +23,7 @@ func GetMenu() gin.HandlerFunc {
 		menuId := c.Param("menu_id")
 		var menu models.Menu
 
-		err := menuCollection.FindOne(ctx, bson.M{"menu_id": menuId}).Decode(&menu)
+		err := menuCollection.FindOne(ctx, catalogFilter(c, bson.M{"menu_id": menuId})).Decode(&menu)
 		defer cancel()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the menu item"})
@@ -34,18 +34,19 @@ func GetMenu() gin.HandlerFunc {
 func GetAllMenus() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		result, err := menuCollection.Find(context.TODO(), bson.M{})
 		defer cancel()
+
+		query, err := parsePageQuery(c, []string{"created_at", "name"}, "name")
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the menu items"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		var allMenu []models.Menu
-		if err = result.All(ctx, &allMenu); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while decoding the menu items"})
+		page, err := findPage[models.Menu](ctx, menuCollection, catalogFilter(c, bson.M{}), query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the menu items"})
 			return
 		}
-		c.JSON(http.StatusOK, allMenu)
+		c.JSON(http.StatusOK, page)
 	}
 }
 
@@ -80,12 +81,14 @@ func CreateMenu() gin.HandlerFunc {
 		menu.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		menu.ID = primitive.NewObjectID()
 		menu.Menu_id = menu.ID.Hex()
+		menu.Restaurant_id = restaurantID(c)
 
 		result, err := menuCollection.InsertOne(ctx, menu)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "menu item was not created"})
 			return
 		}
+		recordAudit(ctx, c, "menu", menu.Menu_id, AuditCreate, nil, menu)
 		defer cancel()
 		c.JSON(http.StatusOK, result)
 
@@ -96,7 +99,7 @@ func UpdateMenu() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		menuId := c.Param("menu_id")
-		filter := bson.M{"menu_id": menuId}
+		filter := tenantFilter(c, bson.M{"menu_id": menuId})
 		var menu models.Menu
 		defer cancel()
 
@@ -131,6 +134,7 @@ func UpdateMenu() gin.HandlerFunc {
 		opt := options.UpdateOptions{
 			Upsert: &upsert,
 		}
+		before := snapshot(ctx, menuCollection, filter)
 		result, err := menuCollection.UpdateOne(ctx, filter, bson.D{
 			{Key: "$set", Value: updateObj},
 		}, &opt,
@@ -139,6 +143,7 @@ func UpdateMenu() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "menu item was not updated"})
 			return
 		}
+		recordAudit(ctx, c, "menu", menuId, AuditUpdate, before, snapshot(ctx, menuCollection, filter))
 		defer cancel()
 		c.JSON(http.StatusOK, result)
 	}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"fmt"
+	"resturnat-management/models"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// prepareModifierGroups gives new groups and options an id and checks their limits make sense
+func prepareModifierGroups(groups []models.ModifierGroup) ([]models.ModifierGroup, error) {
+	prepared := []models.ModifierGroup{}
+	names := map[string]bool{}
+	for _, group := range groups {
+		name := strings.ToLower(strings.TrimSpace(*group.Name))
+		if names[name] {
+			return nil, fmt.Errorf("modifier group %s is listed twice", *group.Name)
+		}
+		names[name] = true
+
+		if group.Selection == models.ModifierSingle {
+			group.Max_choices = 1
+		}
+		if group.Max_choices > 0 && group.Min_choices > group.Max_choices {
+			return nil, fmt.Errorf("%s: min_choices can't be more than max_choices", *group.Name)
+		}
+		if group.Min_choices > len(group.Options) {
+			return nil, fmt.Errorf("%s: min_choices can't be more than the number of options", *group.Name)
+		}
+
+		if group.Group_id == "" {
+			group.Group_id = primitive.NewObjectID().Hex()
+		}
+		options := []models.ModifierOption{}
+		for _, option := range group.Options {
+			if option.Option_id == "" {
+				option.Option_id = primitive.NewObjectID().Hex()
+			}
+			option.Price_delta = toFixed(option.Price_delta, 2)
+			options = append(options, option)
+		}
+		group.Options = options
+		prepared = append(prepared, group)
+	}
+	return prepared, nil
+}
+
+// findModifiers checks the modifiers picked for an order item against the food's groups and
+// fills in their names and prices. it returns what they add to the unit price
+func findModifiers(food models.Food, selected []models.SelectedModifier) ([]models.SelectedModifier, float64, error) {
+	resolved := []models.SelectedModifier{}
+	var delta float64
+	picked := map[string]int{}
+	seen := map[string]bool{}
+
+	for _, pick := range selected {
+		group := findModifierGroup(food, pick.Group_id)
+		if group == nil {
+			return nil, 0, fmt.Errorf("%s has no modifier group %s", *food.Name, pick.Group_id)
+		}
+		var option *models.ModifierOption
+		for i := range group.Options {
+			if group.Options[i].Option_id == pick.Option_id {
+				option = &group.Options[i]
+				break
+			}
+		}
+		if option == nil {
+			return nil, 0, fmt.Errorf("%s has no option %s", *group.Name, pick.Option_id)
+		}
+		if option.Available != nil && !*option.Available {
+			return nil, 0, fmt.Errorf("%s is not available", *option.Name)
+		}
+		if seen[pick.Option_id] {
+			return nil, 0, fmt.Errorf("%s is picked twice", *option.Name)
+		}
+		seen[pick.Option_id] = true
+		picked[group.Group_id]++
+
+		resolved = append(resolved, models.SelectedModifier{
+			Group_id:    group.Group_id,
+			Option_id:   option.Option_id,
+			Group_name:  *group.Name,
+			Name:        *option.Name,
+			Price_delta: option.Price_delta,
+		})
+		delta += option.Price_delta
+	}
+
+	for _, group := range food.Modifier_groups {
+		count := picked[group.Group_id]
+		if count < group.Min_choices {
+			return nil, 0, fmt.Errorf("pick at least %d of %s", group.Min_choices, *group.Name)
+		}
+		if group.Max_choices > 0 && count > group.Max_choices {
+			return nil, 0, fmt.Errorf("pick at most %d of %s", group.Max_choices, *group.Name)
+		}
+	}
+	return resolved, toFixed(delta, 2), nil
+}
+
+func findModifierGroup(food models.Food, groupId string) *models.ModifierGroup {
+	for i := range food.Modifier_groups {
+		if food.Modifier_groups[i].Group_id == groupId {
+			return &food.Modifier_groups[i]
+		}
+	}
+	return nil
+}
+
+// modifiedPrice is the catalog price of a food or variant with the picked modifiers on top
+func modifiedPrice(food models.Food, variant *models.FoodVariant, delta float64) (float64, error) {
+	price := toFixed(catalogPrice(food, variant)+delta, 2)
+	if price < 0 {
+		return 0, fmt.Errorf("the modifiers take more off %s than it costs", *food.Name)
+	}
+	return price, nil
+}
@@ -37,12 +37,14 @@ func CreateNote() gin.HandlerFunc {
 
 		note.ID = primitive.NewObjectID()
 		note.Note_id = note.ID.Hex()
+		note.Restaurant_id = restaurantID(c)
 
 		result, insertErr := noteCollection.InsertOne(ctx, note)
 		if insertErr != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "note item was not created"})
 			return
 		}
+		recordAudit(ctx, c, "note", note.Note_id, AuditCreate, nil, note)
 		defer cancel()
 		c.JSON(http.StatusOK, result)
 	}
@@ -54,18 +56,17 @@ func GetNotes() gin.HandlerFunc {
 
 		defer cancel()
 
-		result, err := noteCollection.Find(context.TODO(), bson.M{})
+		query, err := parsePageQuery(c, []string{"created_at", "updated_at"}, "-created_at")
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing notes"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
-
-		var allNotes []bson.M
-
-		if err = result.All(ctx, &allNotes); err != nil {
+		page, err := findPage[bson.M](ctx, noteCollection, tenantFilter(c, bson.M{}), query)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing notes"})
+			return
 		}
-
-		c.JSON(http.StatusOK, allNotes)
+		c.JSON(http.StatusOK, page)
 	}
 }
 
@@ -83,10 +84,10 @@ func GetNote() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid note id"})
 			return
 		}
-		filter := bson.M{
+		filter := tenantFilter(c, bson.M{
 			"_id":      objID,
 			"order_id": orderId,
-		}
+		})
 
 		err = noteCollection.FindOne(ctx, filter).Decode(&note)
 		if err != nil {
@@ -2,16 +2,18 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"resturnat-management/database"
 	"resturnat-management/models"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var orderCollection *mongo.Collection = database.OpenCollection(database.Client, "order")
@@ -21,7 +23,6 @@ func CreateOrder() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 
 		var order models.Order
-		var table models.Table
 		defer cancel()
 
 		if err := c.BindJSON((&order)); err != nil {
@@ -35,26 +36,60 @@ func CreateOrder() gin.HandlerFunc {
 			return
 		}
 
-		if order.Table_id == nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "table id is required"})
+		order.Order_type = order.CurrentType()
+		if err := checkOrderType(ctx, c, order); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		err := tableCollection.FindOne(ctx, bson.M{"table_id": order.Table_id}).Decode(&table)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "table not found"})
-			return
+		if order.Discount != nil {
+			var err error
+			if order.Discount, err = allowedDiscount(c, order.Discount); err != nil {
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				return
+			}
 		}
 
 		order.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		order.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		order.ID = primitive.NewObjectID()
 		order.Order_id = order.ID.Hex()
+		order.Restaurant_id = restaurantID(c)
+		order.Created_by = c.GetString("uid")
+		// only scheduleOrder gives an order a place in a time slot, and cancels, splits
+		// and merges record themselves
+		order.Slot_id = nil
+		order.Cancel_reason = nil
+		order.Cancel_note = nil
+		order.Cancelled_by = nil
+		order.Split_from = nil
+		order.Merged_into = nil
+		if order.Scheduled_for != nil {
+			if status, err := scheduleOrder(ctx, &order, c.GetString("uid")); err != nil {
+				c.JSON(status, gin.H{"error": err.Error()})
+				return
+			}
+		} else {
+			placeOrder(&order, c.GetString("uid"))
+		}
 
-		result, insertErr := orderCollection.InsertOne(ctx, order)
+		var result *mongo.InsertOneResult
+		insertErr := writeWithEvents(ctx, func(sc mongo.SessionContext) ([]models.OutboxEvent, error) {
+			if err := takeSlotPlace(sc, order); err != nil {
+				return nil, err
+			}
+			var err error
+			result, err = orderCollection.InsertOne(sc, order)
+			return []models.OutboxEvent{orderEvent(models.EventOrderCreated, order)}, err
+		})
+		if errors.Is(insertErr, errSlotFull) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("%s, pick another time than %s", errSlotFull, order.Scheduled_for.Format(time.RFC3339))})
+			return
+		}
 		if insertErr != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "order item was not created"})
 			return
 		}
+		recordAudit(ctx, c, "order", order.Order_id, AuditCreate, nil, order)
 		defer cancel()
 		c.JSON(http.StatusOK, result)
 	}
@@ -66,7 +101,7 @@ func GetOrder() gin.HandlerFunc {
 		orderId := c.Param("order_id")
 		var order models.Order
 
-		err := orderCollection.FindOne(ctx, bson.M{"order_id": orderId}).Decode(&order)
+		err := orderCollection.FindOne(ctx, tenantFilter(c, bson.M{"order_id": orderId})).Decode(&order)
 		defer cancel()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the order item"})
@@ -75,31 +110,96 @@ func GetOrder() gin.HandlerFunc {
 	}
 }
 
+// GetAllOrders lists orders a page at a time, newest first unless sort says otherwise. they can
+// be filtered by table_id, status (comma separated), open=true for orders that aren't final yet,
+// order_type, staff (who took the order) and a from/to range of when they were created
 func GetAllOrders() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 
-		result, err := orderCollection.Find(context.TODO(), bson.M{})
 		defer cancel()
 
+		filter, err := orderListFilter(c)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the order items"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		query, err := parsePageQuery(c, []string{"created_at", "updated_at", "order_date"}, "-created_at")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 
-		var allOrders []bson.M
-		if err = result.All(ctx, &allOrders); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while decoding the order items"})
+		page, err := findPage[bson.M](ctx, orderCollection, filter, query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the orders"})
+			return
 		}
-		c.JSON(http.StatusOK, allOrders)
+		c.JSON(http.StatusOK, page)
 	}
 }
 
+// orderListFilter turns the query parameters of GetAllOrders into a filter
+func orderListFilter(c *gin.Context) (bson.M, error) {
+	filter := tenantFilter(c, bson.M{})
+	if tableId := c.Query("table_id"); tableId != "" {
+		filter["table_id"] = tableId
+	}
+	if orderType := c.Query("order_type"); orderType != "" {
+		if !models.IsValidOrderType(orderType) {
+			return nil, errors.New("unknown order type " + orderType)
+		}
+		filter["order_type"] = orderType
+	}
+	if staff := c.Query("staff"); staff != "" {
+		filter["created_by"] = staff
+	}
+
+	var statuses []string
+	if status := c.Query("status"); status != "" {
+		statuses = strings.Split(status, ",")
+		for _, status := range statuses {
+			if !models.IsValidOrderStatus(status) {
+				return nil, errors.New("unknown order status " + status)
+			}
+		}
+	}
+	open := c.Query("open") == "true"
+	if open && statuses == nil {
+		for status := range models.OrderTransitions {
+			statuses = append(statuses, status)
+		}
+	}
+	if statuses != nil {
+		in := bson.A{}
+		for _, status := range statuses {
+			if open && models.IsFinalOrderStatus(status) {
+				continue
+			}
+			in = append(in, status)
+			// orders from before statuses existed have none and count as placed
+			if status == models.OrderPlaced {
+				in = append(in, nil, "")
+			}
+		}
+		filter["order_status"] = bson.M{"$in": in}
+	}
+
+	createdAt, err := parseQueryRange(c)
+	if err != nil {
+		return nil, err
+	}
+	if createdAt != nil {
+		filter["created_at"] = createdAt
+	}
+	return filter, nil
+}
+
 // sa
 func UpdateOrder() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		orderID := c.Param("order_id")
-		var table models.Table
 		var order models.Order
 		defer cancel()
 
@@ -108,43 +208,285 @@ func UpdateOrder() gin.HandlerFunc {
 			return
 		}
 
+		if order.Order_status != "" || order.Status_history != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the status can only be changed with POST /order/:order_id/status"})
+			return
+		}
+
+		filter := tenantFilter(c, bson.M{"order_id": orderID})
+
+		var existing models.Order
+		if err := orderCollection.FindOne(ctx, filter).Decode(&existing); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+			return
+		}
+		if models.IsFinalOrderStatus(existing.CurrentStatus()) {
+			c.JSON(http.StatusConflict, gin.H{"error": "a " + existing.CurrentStatus() + " order can no longer be changed"})
+			return
+		}
+
 		var updateObj primitive.D
 
+		// the type, table and customer are checked together as the order will be after the update
+		changed := existing
+		changed.Order_type = existing.CurrentType()
+		if order.Order_type != "" {
+			changed.Order_type = order.Order_type
+			if changed.Order_type != models.OrderDineIn {
+				changed.Table_id = nil
+			}
+			updateObj = append(updateObj, bson.E{Key: "order_type", Value: changed.Order_type})
+		}
 		if order.Table_id != nil {
-			err := tableCollection.FindOne(ctx, bson.M{"table_id": order.Table_id}).Decode(&table)
+			changed.Table_id = order.Table_id
+		}
+		if order.Customer != nil {
+			changed.Customer = order.Customer
+			updateObj = append(updateObj, bson.E{Key: "customer", Value: order.Customer})
+		}
+		if order.Promised_at != nil {
+			updateObj = append(updateObj, bson.E{Key: "promised_at", Value: order.Promised_at})
+		}
+		if order.Order_type != "" || order.Table_id != nil || order.Customer != nil {
+			if err := checkOrderType(ctx, c, changed); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "table_id", Value: changed.Table_id})
+		}
+		if order.Discount != nil {
+			discount, err := allowedDiscount(c, order.Discount)
 			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "table not found"})
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
 				return
 			}
-			updateObj = append(updateObj, bson.E{Key: "table_id", Value: order.Table_id})
+			updateObj = append(updateObj, bson.E{Key: "discount", Value: discount})
 		}
 		order.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: order.Updated_at})
 
-		filter := bson.M{"order_id": orderID}
-
-		upsert := true
-		opts := options.UpdateOptions{Upsert: &upsert}
-
-		result, err := orderCollection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: updateObj}}, &opts)
+		// no upsert here, an order must come from CreateOrder so it starts out PLACED
+		before := snapshot(ctx, orderCollection, filter)
+		var result *mongo.UpdateResult
+		var updated models.Order
+		err := writeWithEvents(ctx, func(sc mongo.SessionContext) ([]models.OutboxEvent, error) {
+			var err error
+			if result, err = orderCollection.UpdateOne(sc, filter, bson.D{{Key: "$set", Value: updateObj}}); err != nil {
+				return nil, err
+			}
+			if err := orderCollection.FindOne(sc, filter).Decode(&updated); err != nil {
+				return nil, err
+			}
+			return []models.OutboxEvent{orderEvent(models.EventOrderUpdated, updated)}, nil
+		})
 
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "order update failed"})
 			return
 		}
+		recordAudit(ctx, c, "order", orderID, AuditUpdate, before, updated)
+		if order.Discount != nil {
+			refreshInvoiceTotals(ctx, restaurantID(c), orderID)
+		}
 		defer cancel()
 		c.JSON(http.StatusOK, result)
 
 	}
 }
 
-func orderItemOrderCreator(order models.Order) string {
-	order.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	order.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	order.ID = primitive.NewObjectID()
-	order.Order_id = order.ID.Hex()
-	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-	orderCollection.InsertOne(ctx, order)
-	defer cancel()
-	return order.Order_id
+var (
+	errOrderNotFound     = errors.New("order not found")
+	errIllegalTransition = errors.New("illegal order status transition")
+	errOrderChanged      = errors.New("the order was changed by someone else, try again")
+)
+
+// checkOrderType makes sure an order has what its type needs. a dine-in order is at an
+// existing table, takeaway and delivery orders are at none, and a delivery needs the
+// customer's name, phone and address
+func checkOrderType(ctx context.Context, c *gin.Context, order models.Order) error {
+	switch order.CurrentType() {
+	case models.OrderDineIn:
+		if order.Table_id == nil {
+			return errors.New("table id is required for a dine-in order")
+		}
+		var table models.Table
+		if err := tableCollection.FindOne(ctx, tenantFilter(c, bson.M{"table_id": order.Table_id})).Decode(&table); err != nil {
+			return errors.New("table not found")
+		}
+	case models.OrderTakeaway, models.OrderDelivery:
+		if order.Table_id != nil {
+			return fmt.Errorf("a %s order is not at a table", order.CurrentType())
+		}
+	default:
+		return errors.New("unknown order type " + order.Order_type)
+	}
+
+	if order.CurrentType() == models.OrderDelivery {
+		customer := order.Customer
+		if customer == nil || blank(customer.Name) || blank(customer.Phone) || blank(customer.Address) {
+			return errors.New("a delivery needs the customer's name, phone and address")
+		}
+	}
+	return nil
+}
+
+func blank(value *string) bool {
+	return value == nil || strings.TrimSpace(*value) == ""
+}
+
+// placeOrder puts a new order at the start of its lifecycle
+func placeOrder(order *models.Order, actor string) {
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	order.Order_status = models.OrderPlaced
+	order.Status_history = []models.OrderStatusChange{{Status: models.OrderPlaced, Changed_at: now, Changed_by: actor}}
+}
+
+// changeOrderStatus moves an order along models.OrderTransitions and records who did it,
+// fields are set together with the status. the update only matches while the order still
+// has the status it was read with, so two concurrent transitions can't both win
+func changeOrderStatus(ctx context.Context, restaurantId, orderId, to, actor string, fields bson.M) (before, after models.Order, err error) {
+	filter := bson.M{"order_id": orderId, "restaurant_id": restaurantId}
+	if err = orderCollection.FindOne(ctx, filter).Decode(&before); err != nil {
+		return before, after, errOrderNotFound
+	}
+
+	from := before.CurrentStatus()
+	if !models.CanTransitionOrder(from, to) {
+		return before, after, fmt.Errorf("%w from %s to %s", errIllegalTransition, from, to)
+	}
+
+	guard := orderStatusGuard(before)
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	change := models.OrderStatusChange{Status: to, Changed_at: now, Changed_by: actor}
+	update := bson.M{"order_status": to, "updated_at": now}
+	for key, value := range fields {
+		update[key] = value
+	}
+	err = writeWithEvents(ctx, func(sc mongo.SessionContext) ([]models.OutboxEvent, error) {
+		result, err := orderCollection.UpdateOne(sc, guard, bson.M{
+			"$set":  update,
+			"$push": bson.M{"status_history": change},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, errOrderChanged
+		}
+		// a cancelled pre-order gives its place in the time slot back
+		if to == models.OrderCancelled {
+			if err := releaseSlotPlace(sc, before); err != nil {
+				return nil, err
+			}
+		}
+		if err := orderCollection.FindOne(sc, filter).Decode(&after); err != nil {
+			return nil, err
+		}
+		return []models.OutboxEvent{orderEvent(models.OrderStatusEvent(to), after)}, nil
+	})
+	return before, after, err
+}
+
+// orderStatusGuard matches an order only while it still has the status it was read with
+func orderStatusGuard(order models.Order) bson.M {
+	guard := bson.M{"order_id": order.Order_id, "restaurant_id": order.Restaurant_id, "order_status": order.Order_status}
+	if order.Order_status == "" {
+		guard["order_status"] = bson.M{"$in": bson.A{nil, ""}}
+	}
+	return guard
+}
+
+// TransitionOrder is the only way to change an order's status
+func TransitionOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orderID := c.Param("order_id")
+		var body struct {
+			Status string `json:"status"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !models.IsValidOrderStatus(body.Status) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown order status " + body.Status})
+			return
+		}
+		if body.Status == models.OrderCancelled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cancel an order with POST /order/:order_id/cancel, it needs a reason"})
+			return
+		}
+
+		before, after, err := changeOrderStatus(ctx, restaurantID(c), orderID, body.Status, c.GetString("uid"), nil)
+		switch {
+		case errors.Is(err, errOrderNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, errIllegalTransition):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "allowed": models.OrderTransitions[before.CurrentStatus()]})
+			return
+		case errors.Is(err, errOrderChanged):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "order status update failed"})
+			return
+		}
+
+		recordAudit(ctx, c, "order", orderID, AuditUpdate, before, after)
+		// placing a pre-order by hand releases it early
+		if before.CurrentStatus() == models.OrderScheduled && after.Order_status == models.OrderPlaced {
+			held, fired := fireFirstCourse(ctx, after)
+			for i := range fired {
+				recordAudit(ctx, c, "orderItem", fired[i].OrderItem_id, AuditUpdate, held[i], fired[i])
+			}
+		}
+		c.JSON(http.StatusOK, after)
+	}
+}
+
+// PreviewOrder prices items the way CreateOrderItem would without saving anything, so a
+// waiter can show the guest the bill before the order goes in
+func PreviewOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Order_items []models.OrderItem `json:"order_items" validate:"required,min=1"`
+			Discount    *models.Discount   `json:"discount"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		orderItems, itemErrors := buildOrderItems(ctx, c, primitive.NewObjectID().Hex(), body.Order_items, false)
+		if len(itemErrors) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "some order items are invalid", "order_items": itemErrors})
+			return
+		}
+		discount := body.Discount
+		if discount != nil {
+			var err error
+			if discount, err = allowedDiscount(c, discount); err != nil {
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		breakdown, err := priceOrder(ctx, restaurantID(c), orderItems, discount)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while pricing the order"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"order_items": orderItems, "totals": breakdown})
+	}
 }
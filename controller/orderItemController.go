@@ -2,9 +2,12 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"resturnat-management/database"
+	"resturnat-management/helper"
 	"resturnat-management/models"
 	"time"
 
@@ -12,12 +15,15 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type OrderItemPack struct {
-	Table_id    *string
-	Order_items []models.OrderItem
+	Order_type    string             `json:"order_type" validate:"omitempty,eq=DINE_IN|eq=TAKEAWAY|eq=DELIVERY"`
+	Table_id      *string            `json:"table_id"`
+	Customer      *models.Customer   `json:"customer"`
+	Promised_at   *time.Time         `json:"promised_at"`
+	Scheduled_for *time.Time         `json:"scheduled_for"`
+	Order_items   []models.OrderItem `json:"order_items" validate:"required,min=1"`
 }
 
 var orderItemCollection *mongo.Collection = database.OpenCollection(database.Client, "orderItem")
@@ -25,19 +31,19 @@ var orderItemCollection *mongo.Collection = database.OpenCollection(database.Cli
 func GetAllOrderItems() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-
-		result, err := orderItemCollection.Find(context.TODO(), bson.M{})
 		defer cancel()
+
+		query, err := parsePageQuery(c, []string{"created_at", "updated_at"}, "-created_at")
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching orderItem"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		var allOrderItem []bson.M
-		if err = result.All(ctx, &allOrderItem); err != nil {
-			log.Fatal(err)
+		page, err := findPage[bson.M](ctx, orderItemCollection, tenantFilter(c, bson.M{}), query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching orderItem"})
 			return
 		}
-		c.JSON(http.StatusOK, allOrderItem)
+		c.JSON(http.StatusOK, page)
 	}
 }
 
@@ -48,7 +54,7 @@ func GetOrderItem() gin.HandlerFunc {
 		orderItemId := c.Param("order_item_id")
 		var orderItem models.OrderItem
 
-		err := orderItemCollection.FindOne(ctx, bson.M{"order_item_id": orderItemId}).Decode(&orderItem)
+		err := orderItemCollection.FindOne(ctx, tenantFilter(c, bson.M{"order_item_id": orderItemId})).Decode(&orderItem)
 		defer cancel()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, bson.M{"error": "Error occured while listing the Items"})
@@ -58,102 +64,398 @@ func GetOrderItem() gin.HandlerFunc {
 	}
 }
 
+// CreateOrderItem opens an order together with its items. everything is checked
+// before anything is written and the order and items are inserted in one transaction, so a
+// failure never leaves an order without its items
 func CreateOrderItem() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 		var orderItemPack OrderItemPack
-		var order models.Order
 
-		if err := c.BindJSON(&order); err != nil {
+		if err := c.BindJSON(&orderItemPack); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
-		order.Order_date, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		// order.Updated_at ,_ = time.Parse(time.RFC3339,time.Now().Format(time.RFC3339))
 
-		orderItemsToBeInserted := []interface{}{}
+		validationErr := validate.Struct(orderItemPack)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var order models.Order
+		order.Order_type = orderItemPack.Order_type
+		order.Order_type = order.CurrentType()
 		order.Table_id = orderItemPack.Table_id
-		order_id := orderItemOrderCreator(order)
-		for _, orderItem := range orderItemPack.Order_items {
-			orderItem.Order_id = order_id
-			validationErr := validate.Struct(orderItem)
+		order.Customer = orderItemPack.Customer
+		order.Promised_at = orderItemPack.Promised_at
+		if err := checkOrderType(ctx, c, order); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-			if validationErr != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		order.ID = primitive.NewObjectID()
+		order.Order_id = order.ID.Hex()
+		order.Order_date, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		order.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		order.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		order.Restaurant_id = restaurantID(c)
+		order.Created_by = c.GetString("uid")
+		order.Scheduled_for = orderItemPack.Scheduled_for
+		if order.Scheduled_for != nil {
+			if status, err := scheduleOrder(ctx, &order, c.GetString("uid")); err != nil {
+				c.JSON(status, gin.H{"error": err.Error()})
 				return
 			}
-			orderItem.ID = primitive.NewObjectID()
-			orderItem.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-			orderItem.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-			orderItem.OrderItem_id = orderItem.ID.Hex()
-			var num = toFixed(*orderItem.Unit_Price, 2)
-			orderItem.Unit_Price = &num
+		} else {
+			placeOrder(&order, c.GetString("uid"))
+		}
+
+		orderItems, itemErrors := buildOrderItems(ctx, c, order.Order_id, orderItemPack.Order_items, order.Order_status == models.OrderScheduled)
+		if len(itemErrors) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "some order items are invalid", "order_items": itemErrors})
+			return
+		}
+
+		orderItemsToBeInserted := []interface{}{}
+		for _, orderItem := range orderItems {
 			orderItemsToBeInserted = append(orderItemsToBeInserted, orderItem)
 		}
 
-		insertedOrderItems, err := orderItemCollection.InsertMany(ctx, orderItemsToBeInserted)
+		err := withTransaction(ctx, func(sc mongo.SessionContext) error {
+			if err := takeSlotPlace(sc, order); err != nil {
+				return err
+			}
+			if _, err := orderCollection.InsertOne(sc, order); err != nil {
+				return err
+			}
+			if _, err := orderItemCollection.InsertMany(sc, orderItemsToBeInserted); err != nil {
+				return err
+			}
+			events := []models.OutboxEvent{orderEvent(models.EventOrderCreated, order)}
+			for _, orderItem := range orderItems {
+				events = append(events, orderItemEvent(models.EventOrderItemCreated, orderItem))
+			}
+			return addOutboxEvents(sc, events...)
+		})
+		if errors.Is(err, errSlotFull) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("%s, pick another time than %s", errSlotFull, order.Scheduled_for.Format(time.RFC3339))})
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while inserting the things"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "the order was not created, nothing was saved"})
+			return
 		}
-		defer cancel()
-		c.JSON(http.StatusCreated, insertedOrderItems)
+
+		recordAudit(ctx, c, "order", order.Order_id, AuditCreate, nil, order)
+		for _, orderItem := range orderItems {
+			recordAudit(ctx, c, "orderItem", orderItem.OrderItem_id, AuditCreate, nil, orderItem)
+			publishItemEvent(ctx, helper.KitchenItemCreated, orderItem)
+		}
+		c.JSON(http.StatusCreated, gin.H{"order": order, "order_items": orderItems})
 	}
 }
 
+// buildOrderItems checks the items of a new order against the catalog and prices them.
+// every item is checked before anything is written so all the problems are reported at once,
+// keyed by the item's index. the items of a held order all wait for it to be released
+func buildOrderItems(ctx context.Context, c *gin.Context, orderId string, items []models.OrderItem, hold bool) ([]models.OrderItem, gin.H) {
+	orderItems := []models.OrderItem{}
+	itemErrors := gin.H{}
+
+	// without the settings items just go without a station prep target
+	setting, err := loadSettings(ctx, restaurantID(c))
+	if err != nil {
+		log.Printf("settings of restaurant %s could not be loaded: %v", restaurantID(c), err)
+	}
+
+	// the order's first course goes to the kitchen right away
+	firstCourse := 0
+	for _, orderItem := range items {
+		if course := courseOf(orderItem); firstCourse == 0 || course < firstCourse {
+			firstCourse = course
+		}
+	}
+
+	for i, orderItem := range items {
+		orderItem.Order_id = orderId
+		if orderItem.Food_id == nil {
+			itemErrors[fmt.Sprint(i)] = "food_id is required"
+			continue
+		}
+
+		food, err := findEffectiveFood(ctx, restaurantID(c), *orderItem.Food_id)
+		if err != nil {
+			itemErrors[fmt.Sprint(i)] = "food not found"
+			continue
+		}
+		if food.Available != nil && !*food.Available {
+			itemErrors[fmt.Sprint(i)] = *food.Name + " is not available"
+			continue
+		}
+
+		variant, err := findVariant(food, orderItem.Variant_id)
+		if err != nil {
+			itemErrors[fmt.Sprint(i)] = err.Error()
+			continue
+		}
+		orderItem.Variant_name = nil
+		if variant != nil {
+			orderItem.Variant_name = variant.Name
+		}
+		modifiers, delta, err := findModifiers(food, orderItem.Modifiers)
+		if err != nil {
+			itemErrors[fmt.Sprint(i)] = err.Error()
+			continue
+		}
+		orderItem.Modifiers = modifiers
+
+		// the price and tax rate are snapshotted from the catalog, not taken from the waiter
+		price, err := modifiedPrice(food, variant, delta)
+		if err != nil {
+			itemErrors[fmt.Sprint(i)] = err.Error()
+			continue
+		}
+		if err := priceOrderItem(c, &orderItem, price); err != nil {
+			itemErrors[fmt.Sprint(i)] = err.Error()
+			continue
+		}
+		orderItem.Tax_rate = food.Tax_rate
+		orderItem.Station = food.Station
+		orderItem.Prep_target_minutes = prepTarget(food, setting)
+		orderItem.Course = courseOf(orderItem)
+		queueOrderItem(&orderItem, !hold && orderItem.Course == firstCourse)
+		if orderItem.Discount != nil {
+			if orderItem.Discount, err = allowedDiscount(c, orderItem.Discount); err != nil {
+				itemErrors[fmt.Sprint(i)] = err.Error()
+				continue
+			}
+		}
+
+		if validationErr := validate.Struct(orderItem); validationErr != nil {
+			itemErrors[fmt.Sprint(i)] = validationErr.Error()
+			continue
+		}
+
+		orderItem.ID = primitive.NewObjectID()
+		orderItem.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		orderItem.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		orderItem.OrderItem_id = orderItem.ID.Hex()
+		orderItem.Restaurant_id = restaurantID(c)
+		orderItems = append(orderItems, orderItem)
+	}
+	return orderItems, itemErrors
+}
+
 func UpdateOrderItem() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
 		var orderItem models.OrderItem
-		orderItemId := c.Param("order_item_id")
-		filter := bson.M{"order_item_id": orderItemId}
+		orderItemId := c.Param("id")
+		filter := tenantFilter(c, bson.M{"order_item_id": orderItemId})
 
 		if err := c.BindJSON(&orderItem); err != nil {
 			c.JSON(http.StatusBadRequest, bson.M{"error": err.Error()})
 			return
 		}
+		if orderItem.Kitchen_status != "" {
+			c.JSON(http.StatusBadRequest, bson.M{"error": "the kitchen status can only be changed with POST /orderitem/:id/kitchen-status"})
+			return
+		}
+
+		// items of closed or paid orders stay as they were billed, and a voided item stays voided
+		var existing models.OrderItem
+		if err := orderItemCollection.FindOne(ctx, filter).Decode(&existing); err != nil {
+			c.JSON(http.StatusNotFound, bson.M{"error": "order item not found"})
+			return
+		}
+		if _, status, err := openOrder(ctx, c, existing.Order_id); err != nil {
+			c.JSON(status, bson.M{"error": err.Error()})
+			return
+		}
+		if existing.CurrentKitchenStatus() == models.KitchenVoided {
+			c.JSON(http.StatusConflict, bson.M{"error": "the item was voided, it can't be changed"})
+			return
+		}
 		var updateObj primitive.D
 
-		if orderItem.Unit_Price != nil {
-			updateObj = append(updateObj, bson.E{Key: "unit_price", Value: *orderItem.Unit_Price})
+		// changing the food, variant or modifiers re-prices the item from the catalog, and a
+		// new unit price is checked against the catalog price of what was ordered
+		if orderItem.Food_id != nil || orderItem.Variant_id != nil || orderItem.Modifiers != nil || orderItem.Unit_Price != nil {
+			foodId := existing.Food_id
+			variantId := orderItem.Variant_id
+			if orderItem.Food_id != nil {
+				foodId = orderItem.Food_id
+			} else if variantId == nil {
+				variantId = existing.Variant_id
+			}
+
+			food, err := findEffectiveFood(ctx, restaurantID(c), *foodId)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, bson.M{"error": "food not found"})
+				return
+			}
+			variant, err := findVariant(food, variantId)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, bson.M{"error": err.Error()})
+				return
+			}
+
+			// the modifiers picked for the old food don't carry over to a new one
+			selected := orderItem.Modifiers
+			if selected == nil && orderItem.Food_id == nil {
+				selected = existing.Modifiers
+			}
+			modifiers, delta, err := findModifiers(food, selected)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, bson.M{"error": err.Error()})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "modifiers", Value: modifiers})
+			price, err := modifiedPrice(food, variant, delta)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, bson.M{"error": err.Error()})
+				return
+			}
+
+			if orderItem.Food_id != nil || orderItem.Variant_id != nil {
+				updateObj = append(updateObj, bson.E{Key: "food_id", Value: *foodId})
+				if variant != nil {
+					updateObj = append(updateObj, bson.E{Key: "variant_id", Value: variant.Variant_id})
+					updateObj = append(updateObj, bson.E{Key: "variant_name", Value: variant.Name})
+				} else {
+					updateObj = append(updateObj, bson.E{Key: "variant_id", Value: nil})
+					updateObj = append(updateObj, bson.E{Key: "variant_name", Value: nil})
+				}
+			}
+
+			if err := priceOrderItem(c, &orderItem, price); err != nil {
+				c.JSON(http.StatusForbidden, bson.M{"error": err.Error()})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "unit_price", Value: orderItem.Unit_Price})
+			updateObj = append(updateObj, bson.E{Key: "catalog_price", Value: orderItem.Catalog_price})
+			updateObj = append(updateObj, bson.E{Key: "price_override_by", Value: orderItem.Price_override_by})
+			if orderItem.Food_id != nil {
+				updateObj = append(updateObj, bson.E{Key: "tax_rate", Value: food.Tax_rate})
+				updateObj = append(updateObj, bson.E{Key: "station", Value: food.Station})
+				setting, err := loadSettings(ctx, restaurantID(c))
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, bson.M{"error": "error occured while fetching the settings"})
+					return
+				}
+				updateObj = append(updateObj, bson.E{Key: "prep_target_minutes", Value: prepTarget(food, setting)})
+			}
+		}
+		if orderItem.Discount != nil {
+			discount, err := allowedDiscount(c, orderItem.Discount)
+			if err != nil {
+				c.JSON(http.StatusForbidden, bson.M{"error": err.Error()})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "discount", Value: discount})
 		}
 		if orderItem.Quantity != nil {
+			if *orderItem.Quantity < 1 {
+				c.JSON(http.StatusBadRequest, bson.M{"error": "quantity must be at least 1"})
+				return
+			}
 			updateObj = append(updateObj, bson.E{Key: "quantity", Value: *orderItem.Quantity})
 		}
-		if orderItem.Food_id != nil {
-			updateObj = append(updateObj, bson.E{Key: "food_id", Value: *orderItem.Food_id})
+		// a course can be changed until it is fired
+		if orderItem.Course != 0 {
+			if orderItem.Course < 1 {
+				c.JSON(http.StatusBadRequest, bson.M{"error": "course must be at least 1"})
+				return
+			}
+			if existing.Fired_at != nil {
+				c.JSON(http.StatusConflict, bson.M{"error": "the item was already fired, its course can't change"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "course", Value: orderItem.Course})
+		}
+		if orderItem.Portion != nil {
+			if err := validate.Var(*orderItem.Portion, "eq=S|eq=M|eq=L"); err != nil {
+				c.JSON(http.StatusBadRequest, bson.M{"error": "portion must be S, M or L"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "portion", Value: *orderItem.Portion})
 		}
 
 		orderItem.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: orderItem.Updated_at})
 
-		upsert := true
-		opt := options.UpdateOptions{
-			Upsert: &upsert,
+		before := snapshot(ctx, orderItemCollection, filter)
+		var result *mongo.UpdateResult
+		var updated models.OrderItem
+		err := writeWithEvents(ctx, func(sc mongo.SessionContext) ([]models.OutboxEvent, error) {
+			var err error
+			result, err = orderItemCollection.UpdateOne(
+				sc,
+				filter,
+				bson.D{
+					bson.E{Key: "$set", Value: updateObj},
+				},
+			)
+			if err != nil {
+				return nil, err
+			}
+			if result.MatchedCount == 0 {
+				return nil, errOrderItemNotFound
+			}
+			if err := orderItemCollection.FindOne(sc, filter).Decode(&updated); err != nil {
+				return nil, err
+			}
+			return []models.OutboxEvent{orderItemEvent(models.EventOrderItemUpdated, updated)}, nil
+		})
+		if errors.Is(err, errOrderItemNotFound) {
+			c.JSON(http.StatusNotFound, bson.M{"error": err.Error()})
+			return
 		}
-
-		result, err := orderItemCollection.UpdateOne(
-			ctx,
-			filter,
-			bson.D{
-				bson.E{Key: "$set", Value: updateObj},
-			},
-			&opt,
-		)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, bson.M{"error": "Error in updating the UpdateOrderITem"})
 			return
 		}
+		recordAudit(ctx, c, "orderItem", orderItemId, AuditUpdate, before, updated)
+
+		publishItemEvent(ctx, helper.KitchenItemUpdated, updated)
+		refreshInvoiceTotals(ctx, restaurantID(c), updated.Order_id)
 		c.JSON(http.StatusOK, result)
 	}
 }
 
+// priceOrderItem sets the unit price an item is charged at. it is the catalog price unless
+// the caller asked for a different one and holds the price override permission, in which
+// case who overrode it is kept next to the catalog price
+func priceOrderItem(c *gin.Context, orderItem *models.OrderItem, catalog float64) error {
+	orderItem.Catalog_price = &catalog
+	orderItem.Price_override_by = nil
+
+	if orderItem.Unit_Price == nil || toFixed(*orderItem.Unit_Price, 2) == catalog {
+		orderItem.Unit_Price = &catalog
+		return nil
+	}
+	if !callerHasPermission(c, helper.PermPriceOverride) {
+		return fmt.Errorf("unit_price %.2f doesn't match the menu price %.2f, only a manager can override it", *orderItem.Unit_Price, catalog)
+	}
+	if *orderItem.Unit_Price < 0 {
+		return errors.New("unit_price can't be negative")
+	}
+	price := toFixed(*orderItem.Unit_Price, 2)
+	actor := c.GetString("uid")
+	orderItem.Unit_Price = &price
+	orderItem.Price_override_by = &actor
+	return nil
+}
+
 func GetOrderItemsByOrder() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		orderID := c.Param("order_id")
+		orderID := c.Param("id")
 
-		allOrderItems, err := ItemsByOrder(orderID)
+		allOrderItems, err := ItemsByOrder(restaurantID(c), orderID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, bson.M{"error": "Error occured while listing the items by order id"})
 			return
@@ -162,24 +464,22 @@ func GetOrderItemsByOrder() gin.HandlerFunc {
 	}
 }
 
-func ItemsByOrder(id string) (OrderItems []primitive.M, err error) {
+func ItemsByOrder(restaurantId, id string) (OrderItems []primitive.M, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
 	defer cancel()
 
 	// Match Stage: Filter order items for a specific order_id
+	//  voided items are not charged
 	matchStage := bson.D{{
-		Key: "$match", Value: bson.D{{Key: "order_id", Value: id}},
+		Key: "$match", Value: bson.D{
+			{Key: "order_id", Value: id},
+			{Key: "restaurant_id", Value: restaurantId},
+			{Key: "kitchen_status", Value: bson.D{{Key: "$ne", Value: models.KitchenVoided}}},
+		},
 	}}
 
 	//  Lookup food details for each order item
-	lookupFoodStage := bson.D{{
-		Key: "$lookup", Value: bson.D{
-			{Key: "from", Value: "food"},
-			{Key: "localField", Value: "food_id"},
-			{Key: "foreignField", Value: "food_id"},
-			{Key: "as", Value: "food"},
-		},
-	}}
+	lookupFoodStage := catalogLookup("food", "food_id", "food_id", "food", restaurantId)
 
 	unwindFoodStage := bson.D{{
 		Key: "$unwind", Value: bson.D{
@@ -189,14 +489,7 @@ func ItemsByOrder(id string) (OrderItems []primitive.M, err error) {
 	}}
 
 	//  Lookup order details
-	lookupOrderStage := bson.D{{
-		Key: "$lookup", Value: bson.D{
-			{Key: "from", Value: "order"},
-			{Key: "localField", Value: "order_id"},
-			{Key: "foreignField", Value: "order_id"},
-			{Key: "as", Value: "order"},
-		},
-	}}
+	lookupOrderStage := tenantLookup("order", "order_id", "order_id", "order", restaurantId)
 
 	unwindOrderStage := bson.D{{
 		Key: "$unwind", Value: bson.D{
@@ -206,14 +499,7 @@ func ItemsByOrder(id string) (OrderItems []primitive.M, err error) {
 	}}
 
 	//  Lookup table details (via order.table_id)
-	lookupTableStage := bson.D{{
-		Key: "$lookup", Value: bson.D{
-			{Key: "from", Value: "table"},
-			{Key: "localField", Value: "order.table_id"},
-			{Key: "foreignField", Value: "table_id"},
-			{Key: "as", Value: "table"},
-		},
-	}}
+	lookupTableStage := tenantLookup("table", "order.table_id", "table_id", "table", restaurantId)
 
 	unwindTableStage := bson.D{{
 		Key: "$unwind", Value: bson.D{
@@ -223,18 +509,28 @@ func ItemsByOrder(id string) (OrderItems []primitive.M, err error) {
 	}}
 
 	//  Project required fields
+	//  the line total is quantity x the unit price snapshotted when the item was ordered,
+	//  later menu price changes don't touch existing orders
+	unitPrice := "$unit_price"
+	quantity := bson.D{{Key: "$ifNull", Value: bson.A{"$quantity", 1}}}
 	projectStage := bson.D{{
 		Key: "$project", Value: bson.D{
 			{Key: "_id", Value: 0},
-			{Key: "amount", Value: "$food.price"},
+			{Key: "amount", Value: bson.D{{Key: "$round", Value: bson.A{
+				bson.D{{Key: "$multiply", Value: bson.A{unitPrice, quantity}}}, 2,
+			}}}},
 			{Key: "total_count", Value: 1},
+			{Key: "order_item_id", Value: "$order_item_id"},
 			{Key: "food_name", Value: "$food.name"},
 			{Key: "food_image", Value: "$food.food_image"},
 			{Key: "table_number", Value: "$table.table_number"},
 			{Key: "table_id", Value: "$table.table_id"},
 			{Key: "order_id", Value: "$order.order_id"},
-			{Key: "price", Value: "$food.price"},
-			{Key: "quantity", Value: 1},
+			{Key: "price", Value: unitPrice},
+			{Key: "quantity", Value: quantity},
+			{Key: "portion", Value: "$portion"},
+			{Key: "variant_name", Value: "$variant_name"},
+			{Key: "modifiers", Value: "$modifiers"},
 		},
 	}}
 
@@ -248,6 +544,7 @@ func ItemsByOrder(id string) (OrderItems []primitive.M, err error) {
 			}},
 			{Key: "payment_due", Value: bson.D{{Key: "$sum", Value: "$amount"}}},
 			{Key: "total_count", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "total_quantity", Value: bson.D{{Key: "$sum", Value: "$quantity"}}},
 			{Key: "order_items", Value: bson.D{{Key: "$push", Value: "$$ROOT"}}},
 		},
 	}}
@@ -256,18 +553,18 @@ func ItemsByOrder(id string) (OrderItems []primitive.M, err error) {
 	projectStage2 := bson.D{{
 		Key: "$project", Value: bson.D{
 			{Key: "_id", Value: 0},
-			{Key: "payment_due", Value: 1},
+			{Key: "payment_due", Value: bson.D{{Key: "$round", Value: bson.A{"$payment_due", 2}}}},
 			{Key: "total_count", Value: 1},
+			{Key: "total_quantity", Value: 1},
 			{Key: "table_number", Value: "$_id.table_number"},
 			{Key: "order_items", Value: 1},
 		},
 	}}
 
-	//  Execute aggregation pipeline
-	result, err := orderItemCollection.Aggregate(ctx, mongo.Pipeline{
-		matchStage,
-		lookupFoodStage,
-		unwindFoodStage,
+	//  Execute aggregation pipeline, the branch's food overrides are merged in right after the food lookup
+	pipeline := mongo.Pipeline{matchStage, lookupFoodStage, unwindFoodStage}
+	pipeline = append(pipeline, foodOverrideStages(restaurantId, "food")...)
+	pipeline = append(pipeline,
 		lookupOrderStage,
 		unwindOrderStage,
 		lookupTableStage,
@@ -275,7 +572,9 @@ func ItemsByOrder(id string) (OrderItems []primitive.M, err error) {
 		projectStage,
 		groupStage,
 		projectStage2,
-	})
+	)
+
+	result, err := orderItemCollection.Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, err
 	}
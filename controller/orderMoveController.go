@@ -0,0 +1,507 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"resturnat-management/helper"
+	"resturnat-management/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var errItemsChanged = errors.New("some order items were changed by someone else, try again")
+
+// openOrder loads an order whose items may still change. final orders and orders with a
+// paid invoice are left as they are, the status code says why the order was refused
+func openOrder(ctx context.Context, c *gin.Context, orderId string) (models.Order, int, error) {
+	var order models.Order
+	if err := orderCollection.FindOne(ctx, tenantFilter(c, bson.M{"order_id": orderId})).Decode(&order); err != nil {
+		return order, http.StatusNotFound, fmt.Errorf("order %s not found", orderId)
+	}
+	if models.IsFinalOrderStatus(order.CurrentStatus()) {
+		return order, http.StatusConflict, fmt.Errorf("order %s is %s", orderId, order.CurrentStatus())
+	}
+	paid, err := invoiceColletion.CountDocuments(ctx, tenantFilter(c, bson.M{"order_id": orderId, "payment_status": "COMPLETED"}))
+	if err != nil {
+		return order, http.StatusInternalServerError, errors.New("error occured while fetching the invoices")
+	}
+	if paid > 0 {
+		return order, http.StatusConflict, fmt.Errorf("order %s is already paid", orderId)
+	}
+	return order, 0, nil
+}
+
+// sameSchedule refuses to mix a scheduled order with one that is already being served, the
+// scheduled one's items aren't due in the kitchen yet
+func sameSchedule(a, b models.Order) error {
+	aScheduled := a.CurrentStatus() == models.OrderScheduled
+	if aScheduled == (b.CurrentStatus() == models.OrderScheduled) {
+		return nil
+	}
+	if aScheduled {
+		a, b = b, a
+	}
+	return fmt.Errorf("order %s is scheduled and order %s is not", b.Order_id, a.Order_id)
+}
+
+// pickOrderItems loads the listed items of an order, all of them have to be on it
+func pickOrderItems(ctx context.Context, c *gin.Context, orderId string, itemIds []string) ([]models.OrderItem, error) {
+	if len(itemIds) == 0 {
+		return nil, errors.New("order_item_ids is required")
+	}
+	ids := bson.A{}
+	seen := map[string]bool{}
+	for _, id := range itemIds {
+		if seen[id] {
+			return nil, fmt.Errorf("order item %s is listed twice", id)
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	var orderItems []models.OrderItem
+	cursor, err := orderItemCollection.Find(ctx, tenantFilter(c, bson.M{"order_id": orderId, "order_item_id": bson.M{"$in": ids}}))
+	if err == nil {
+		err = cursor.All(ctx, &orderItems)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(orderItems) != len(itemIds) {
+		return nil, fmt.Errorf("some of the order items are not on order %s", orderId)
+	}
+	return orderItems, nil
+}
+
+// splitOffOrder is a new order for items taken off source, at another table or else like
+// source. it picks up where source is in its lifecycle, an order discount stays with source
+func splitOffOrder(ctx context.Context, c *gin.Context, source models.Order, tableId *string) (models.Order, error) {
+	var order models.Order
+	order.Order_type = source.CurrentType()
+	order.Customer = source.Customer
+	order.Promised_at = source.Promised_at
+	order.Scheduled_for = source.Scheduled_for
+	if tableId == nil {
+		tableId = source.Table_id
+	} else {
+		order.Order_type = models.OrderDineIn
+		var table models.Table
+		if err := tableCollection.FindOne(ctx, tenantFilter(c, bson.M{"table_id": tableId})).Decode(&table); err != nil {
+			return order, fmt.Errorf("table %s not found", *tableId)
+		}
+	}
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	order.ID = primitive.NewObjectID()
+	order.Order_id = order.ID.Hex()
+	order.Table_id = tableId
+	order.Order_date = now
+	order.Created_at = now
+	order.Updated_at = now
+	order.Restaurant_id = source.Restaurant_id
+	order.Created_by = c.GetString("uid")
+	order.Split_from = &source.Order_id
+	order.Order_status = source.CurrentStatus()
+	order.Status_history = []models.OrderStatusChange{{Status: order.Order_status, Changed_at: now, Changed_by: c.GetString("uid")}}
+	return order, nil
+}
+
+// moveOrderItems puts order items on another order and adds the move to their history and the
+// outbox. an item only moves while it is still on the order it was read from
+func moveOrderItems(sc mongo.SessionContext, orderItems []models.OrderItem, toOrderId, reason, actor string) error {
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	for _, orderItem := range orderItems {
+		move := models.OrderItemMove{
+			From_order_id: orderItem.Order_id,
+			To_order_id:   toOrderId,
+			Reason:        reason,
+			Moved_at:      now,
+			Moved_by:      actor,
+		}
+		guard := bson.M{"order_item_id": orderItem.OrderItem_id, "restaurant_id": orderItem.Restaurant_id, "order_id": orderItem.Order_id}
+		result, err := orderItemCollection.UpdateOne(sc, guard, bson.M{
+			"$set":  bson.M{"order_id": toOrderId, "updated_at": now},
+			"$push": bson.M{"order_history": move},
+		})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errItemsChanged
+		}
+		var moved models.OrderItem
+		filter := bson.M{"order_item_id": orderItem.OrderItem_id, "restaurant_id": orderItem.Restaurant_id}
+		if err := orderItemCollection.FindOne(sc, filter).Decode(&moved); err != nil {
+			return err
+		}
+		if err := addOutboxEvents(sc, orderItemEvent(models.EventOrderItemMoved, moved)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveFailed answers a move, split or merge whose transaction did not go through
+func moveFailed(c *gin.Context, err error) {
+	if errors.Is(err, errItemsChanged) || errors.Is(err, errOrderChanged) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "the order items were not moved, nothing was saved"})
+}
+
+// afterMove audits the moved items and tells the kitchen, then settles every order that
+// gained or lost items. it returns the items as they are now
+func afterMove(ctx context.Context, c *gin.Context, orderItems []models.OrderItem, orderIds ...string) []models.OrderItem {
+	moved := []models.OrderItem{}
+	for _, orderItem := range orderItems {
+		var after models.OrderItem
+		filter := bson.M{"order_item_id": orderItem.OrderItem_id, "restaurant_id": orderItem.Restaurant_id}
+		if err := orderItemCollection.FindOne(ctx, filter).Decode(&after); err != nil {
+			continue
+		}
+		recordAudit(ctx, c, "orderItem", orderItem.OrderItem_id, AuditUpdate, orderItem, after)
+		publishItemEvent(ctx, helper.KitchenItemUpdated, after)
+		moved = append(moved, after)
+	}
+	for _, orderId := range orderIds {
+		settleOrder(ctx, c, orderId)
+	}
+	return moved
+}
+
+// settleOrder re-prices the invoices of an order that gained or lost items, and moves it on
+// to READY when what is left on it is all ready
+func settleOrder(ctx context.Context, c *gin.Context, orderId string) {
+	refreshInvoiceTotals(ctx, restaurantID(c), orderId)
+	if orderItemsReady(ctx, restaurantID(c), orderId) {
+		advanceOrder(ctx, c, orderId, models.OrderReady)
+	}
+}
+
+// MoveOrderItems moves some items of an order to another open order, or to a new order
+// at another table. a scheduled order only swaps items with another scheduled order
+func MoveOrderItems() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Order_item_ids []string `json:"order_item_ids"`
+			To_order_id    *string  `json:"to_order_id"`
+			To_table_id    *string  `json:"to_table_id"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if (body.To_order_id == nil) == (body.To_table_id == nil) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "send either to_order_id or to_table_id"})
+			return
+		}
+
+		source, status, err := openOrder(ctx, c, c.Param("order_id"))
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		orderItems, err := pickOrderItems(ctx, c, source.Order_id, body.Order_item_ids)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var target models.Order
+		created := body.To_table_id != nil
+		if created {
+			if target, err = splitOffOrder(ctx, c, source, body.To_table_id); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		} else {
+			if *body.To_order_id == source.Order_id {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "the items are already on that order"})
+				return
+			}
+			if target, status, err = openOrder(ctx, c, *body.To_order_id); err != nil {
+				c.JSON(status, gin.H{"error": err.Error()})
+				return
+			}
+			if err := sameSchedule(source, target); err != nil {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		err = withTransaction(ctx, func(sc mongo.SessionContext) error {
+			if created {
+				if _, err := orderCollection.InsertOne(sc, target); err != nil {
+					return err
+				}
+				if err := addOutboxEvents(sc, orderEvent(models.EventOrderCreated, target)); err != nil {
+					return err
+				}
+			}
+			return moveOrderItems(sc, orderItems, target.Order_id, models.ItemMoved, c.GetString("uid"))
+		})
+		if err != nil {
+			moveFailed(c, err)
+			return
+		}
+
+		if created {
+			recordAudit(ctx, c, "order", target.Order_id, AuditCreate, nil, target)
+		}
+		moved := afterMove(ctx, c, orderItems, source.Order_id, target.Order_id)
+		c.JSON(http.StatusOK, gin.H{"from_order_id": source.Order_id, "order": target, "order_items": moved})
+	}
+}
+
+// SplitOrder takes parts of an order off into new orders, each at the order's table unless
+// the part names another one. at least one item has to stay on the order
+func SplitOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Parts []struct {
+				Order_item_ids []string `json:"order_item_ids"`
+				Table_id       *string  `json:"table_id"`
+			} `json:"parts"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(body.Parts) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "parts is required"})
+			return
+		}
+
+		source, status, err := openOrder(ctx, c, c.Param("order_id"))
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		itemIds := []string{}
+		for _, part := range body.Parts {
+			if len(part.Order_item_ids) == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "every part needs order_item_ids"})
+				return
+			}
+			itemIds = append(itemIds, part.Order_item_ids...)
+		}
+		// checks an item isn't in two parts and every item is on the order
+		if _, err := pickOrderItems(ctx, c, source.Order_id, itemIds); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		remaining, err := orderItemCollection.CountDocuments(ctx, tenantFilter(c, bson.M{"order_id": source.Order_id}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the order items"})
+			return
+		}
+		if int(remaining) <= len(itemIds) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "leave at least one item on the order, or move the items instead"})
+			return
+		}
+
+		orders := []models.Order{}
+		parts := [][]models.OrderItem{}
+		for _, part := range body.Parts {
+			order, err := splitOffOrder(ctx, c, source, part.Table_id)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			orderItems, err := pickOrderItems(ctx, c, source.Order_id, part.Order_item_ids)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			orders = append(orders, order)
+			parts = append(parts, orderItems)
+		}
+
+		err = withTransaction(ctx, func(sc mongo.SessionContext) error {
+			for i, order := range orders {
+				if _, err := orderCollection.InsertOne(sc, order); err != nil {
+					return err
+				}
+				if err := addOutboxEvents(sc, orderEvent(models.EventOrderCreated, order)); err != nil {
+					return err
+				}
+				if err := moveOrderItems(sc, parts[i], order.Order_id, models.ItemSplit, c.GetString("uid")); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			moveFailed(c, err)
+			return
+		}
+
+		split := []gin.H{}
+		for i, order := range orders {
+			recordAudit(ctx, c, "order", order.Order_id, AuditCreate, nil, order)
+			moved := afterMove(ctx, c, parts[i], order.Order_id)
+			split = append(split, gin.H{"order": order, "order_items": moved})
+		}
+		settleOrder(ctx, c, source.Order_id)
+		c.JSON(http.StatusOK, gin.H{"order_id": source.Order_id, "orders": split})
+	}
+}
+
+// MergeOrders moves every item of the listed orders onto this one. the merged orders end up
+// MERGED with merged_into pointing here, their item history says where each item came from.
+// scheduled orders are only merged with each other
+func MergeOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Order_ids []string `json:"order_ids"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(body.Order_ids) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "order_ids is required"})
+			return
+		}
+
+		target, status, err := openOrder(ctx, c, c.Param("order_id"))
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		sources := []models.Order{}
+		sourceItems := [][]models.OrderItem{}
+		seen := map[string]bool{target.Order_id: true}
+		for _, orderId := range body.Order_ids {
+			if seen[orderId] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("order %s is listed twice or is the order merged into", orderId)})
+				return
+			}
+			seen[orderId] = true
+
+			source, status, err := openOrder(ctx, c, orderId)
+			if err != nil {
+				c.JSON(status, gin.H{"error": err.Error()})
+				return
+			}
+			if err := sameSchedule(source, target); err != nil {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			var orderItems []models.OrderItem
+			cursor, err := orderItemCollection.Find(ctx, tenantFilter(c, bson.M{"order_id": orderId}))
+			if err == nil {
+				err = cursor.All(ctx, &orderItems)
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the order items"})
+				return
+			}
+			sources = append(sources, source)
+			sourceItems = append(sourceItems, orderItems)
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		var voided []models.Invoice
+		err = withTransaction(ctx, func(sc mongo.SessionContext) error {
+			voided = nil
+			for i, source := range sources {
+				if err := moveOrderItems(sc, sourceItems[i], target.Order_id, models.ItemMerged, c.GetString("uid")); err != nil {
+					return err
+				}
+				change := models.OrderStatusChange{Status: models.OrderMerged, Changed_at: now, Changed_by: c.GetString("uid")}
+				result, err := orderCollection.UpdateOne(sc, orderStatusGuard(source), bson.M{
+					"$set":  bson.M{"order_status": models.OrderMerged, "merged_into": target.Order_id, "updated_at": now},
+					"$push": bson.M{"status_history": change},
+				})
+				if err != nil {
+					return err
+				}
+				if result.MatchedCount == 0 {
+					return errOrderChanged
+				}
+				if err := releaseSlotPlace(sc, source); err != nil {
+					return err
+				}
+				var merged models.Order
+				if err := orderCollection.FindOne(sc, bson.M{"order_id": source.Order_id, "restaurant_id": source.Restaurant_id}).Decode(&merged); err != nil {
+					return err
+				}
+				if err := addOutboxEvents(sc, orderEvent(models.OrderStatusEvent(models.OrderMerged), merged)); err != nil {
+					return err
+				}
+				invoices, err := voidOrderInvoices(sc, source, now)
+				if err != nil {
+					return err
+				}
+				voided = append(voided, invoices...)
+			}
+			return nil
+		})
+		if err != nil {
+			moveFailed(c, err)
+			return
+		}
+
+		moved := []models.OrderItem{}
+		for i, source := range sources {
+			filter := bson.M{"order_id": source.Order_id, "restaurant_id": source.Restaurant_id}
+			recordAudit(ctx, c, "order", source.Order_id, AuditUpdate, source, snapshot(ctx, orderCollection, filter))
+			moved = append(moved, afterMove(ctx, c, sourceItems[i], source.Order_id)...)
+		}
+		for _, invoice := range voided {
+			filter := bson.M{"invoice_id": invoice.Invoice_id, "restaurant_id": invoice.Restaurant_id}
+			recordAudit(ctx, c, "invoice", invoice.Invoice_id, AuditUpdate, invoice, snapshot(ctx, invoiceColletion, filter))
+		}
+		settleOrder(ctx, c, target.Order_id)
+		c.JSON(http.StatusOK, gin.H{"order_id": target.Order_id, "merged": body.Order_ids, "order_items": moved})
+	}
+}
+
+// voidOrderInvoices voids the unpaid invoices of an order that was merged away. its items are
+// billed on the order they were merged into, so these would otherwise wait for a payment that
+// never comes. openOrder already refused orders with a paid invoice. it returns the invoices as
+// they were before
+func voidOrderInvoices(sc mongo.SessionContext, order models.Order, now time.Time) ([]models.Invoice, error) {
+	unpaid := bson.M{"order_id": order.Order_id, "restaurant_id": order.Restaurant_id, "payment_status": bson.M{"$in": bson.A{"PENDING", "FAILED"}}}
+	var invoices []models.Invoice
+	cursor, err := invoiceColletion.Find(sc, unpaid)
+	if err == nil {
+		err = cursor.All(sc, &invoices)
+	}
+	if err != nil || len(invoices) == 0 {
+		return nil, err
+	}
+	if _, err := invoiceColletion.UpdateMany(sc, unpaid, bson.M{"$set": bson.M{"payment_status": "VOIDED", "updated_at": now}}); err != nil {
+		return nil, err
+	}
+	events := []models.OutboxEvent{}
+	for _, invoice := range invoices {
+		voided := "VOIDED"
+		invoice.Payment_status = &voided
+		invoice.Updated_at = now
+		events = append(events, invoiceEvent(models.EventInvoiceUpdated, invoice))
+	}
+	if err := addOutboxEvents(sc, events...); err != nil {
+		return nil, err
+	}
+	return invoices, nil
+}
@@ -0,0 +1,304 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"resturnat-management/database"
+	"resturnat-management/helper"
+	"resturnat-management/models"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// how many outbox events the relay publishes per run
+	outboxBatchSize = 500
+	// a relay holds an event this long while it publishes it, a failed event is retried after it
+	outboxLease = 30 * time.Second
+)
+
+var outboxCollection *mongo.Collection = database.OpenCollection(database.Client, "outbox")
+
+func newOutboxEvent(eventType, entity, entityId, restaurantId string, data interface{}) models.OutboxEvent {
+	event := models.OutboxEvent{
+		ID:            primitive.NewObjectID(),
+		Type:          eventType,
+		Entity:        entity,
+		Entity_id:     entityId,
+		Restaurant_id: restaurantId,
+		Data:          toDocument(data),
+		Created_at:    time.Now().UTC(),
+	}
+	event.Event_id = event.ID.Hex()
+	return event
+}
+
+func orderEvent(eventType string, order models.Order) models.OutboxEvent {
+	return newOutboxEvent(eventType, "order", order.Order_id, order.Restaurant_id, order)
+}
+
+func orderItemEvent(eventType string, orderItem models.OrderItem) models.OutboxEvent {
+	return newOutboxEvent(eventType, "orderItem", orderItem.OrderItem_id, orderItem.Restaurant_id, orderItem)
+}
+
+func invoiceEvent(eventType string, invoice models.Invoice) models.OutboxEvent {
+	return newOutboxEvent(eventType, "invoice", invoice.Invoice_id, invoice.Restaurant_id, invoice)
+}
+
+// addOutboxEvents writes events to the outbox inside a transaction that is making their changes
+func addOutboxEvents(sc mongo.SessionContext, events ...models.OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, 0, len(events))
+	for _, event := range events {
+		docs = append(docs, event)
+	}
+	_, err := outboxCollection.InsertMany(sc, docs)
+	return err
+}
+
+// writeWithEvents runs a change in a transaction that also writes the events fn returns to the
+// outbox, so an event is there exactly when its change was committed
+func writeWithEvents(ctx context.Context, fn func(sc mongo.SessionContext) ([]models.OutboxEvent, error)) error {
+	return withTransaction(ctx, func(sc mongo.SessionContext) error {
+		events, err := fn(sc)
+		if err != nil {
+			return err
+		}
+		return addOutboxEvents(sc, events...)
+	})
+}
+
+// RelayOutbox publishes outbox events to the restaurants' event streams until ctx is done, main
+// runs it in the background
+func RelayOutbox(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			relayOutbox(ctx)
+		}
+	}
+}
+
+// relayOutbox publishes the events that aren't on a stream yet in the order they were written.
+// an event is marked published only after redis took it, so one that was published right before
+// a crash is published again: delivery is at least once and consumers drop repeats by event_id.
+// a relay leases an event while it publishes it so relays on other api instances leave it alone,
+// and while an event of a restaurant is leased or failing its later events wait, which keeps
+// every stream in order
+func relayOutbox(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var events []models.OutboxEvent
+	cursor, err := outboxCollection.Find(ctx, bson.M{"published_at": nil},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(outboxBatchSize))
+	if err == nil {
+		err = cursor.All(ctx, &events)
+	}
+	if err != nil {
+		log.Printf("outbox events could not be fetched: %v", err)
+		return
+	}
+
+	waiting := map[string]bool{}
+	for _, event := range events {
+		if waiting[event.Restaurant_id] {
+			continue
+		}
+		now := time.Now().UTC()
+		claim := bson.M{"_id": event.ID, "published_at": nil, "$or": bson.A{
+			bson.M{"locked_until": nil},
+			bson.M{"locked_until": bson.M{"$lte": now}},
+		}}
+		result, err := outboxCollection.UpdateOne(ctx, claim, bson.M{
+			"$set": bson.M{"locked_until": now.Add(outboxLease)},
+			"$inc": bson.M{"attempts": 1},
+		})
+		if err != nil || result.ModifiedCount == 0 {
+			waiting[event.Restaurant_id] = true
+			continue
+		}
+
+		// a failed event keeps its lease, so it is retried once the lease runs out
+		streamId, err := relayEvent(ctx, event)
+		if err != nil {
+			log.Printf("outbox event %s was not published: %v", event.Event_id, err)
+			outboxCollection.UpdateOne(ctx, bson.M{"_id": event.ID}, bson.M{"$set": bson.M{"last_error": err.Error()}})
+			waiting[event.Restaurant_id] = true
+			continue
+		}
+		_, err = outboxCollection.UpdateOne(ctx, bson.M{"_id": event.ID}, bson.M{"$set": bson.M{
+			"published_at": time.Now().UTC(),
+			"stream_id":    streamId,
+			"locked_until": nil,
+			"last_error":   nil,
+		}})
+		if err != nil {
+			log.Printf("outbox event %s was published but not marked: %v", event.Event_id, err)
+			waiting[event.Restaurant_id] = true
+		}
+	}
+}
+
+// relayEvent queues the event's webhook deliveries and puts it on its restaurant's stream
+func relayEvent(ctx context.Context, event models.OutboxEvent) (string, error) {
+	if err := queueWebhookDeliveries(ctx, event); err != nil {
+		return "", err
+	}
+	return helper.PublishOutboxEvent(ctx, event)
+}
+
+// GetEvents replays the restaurant's event stream after the stream id in after, from the oldest
+// event kept without it. next_cursor is the id to send as after for the next page. complete is
+// false when events after it were already trimmed from the stream
+func GetEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		after := c.Query("after")
+		if after != "" && !helper.IsStreamId(after) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "after must be a stream id like 1700000000000-0"})
+			return
+		}
+		limit, err := queryNumber(c, "limit")
+		if err != nil || (limit != nil && (*limit < 1 || *limit > maxPageLimit)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a number from 1 to 200"})
+			return
+		}
+		count := defaultPageLimit
+		if limit != nil {
+			count = *limit
+		}
+
+		// one more than asked for tells whether there is a next page
+		events, complete, err := helper.OrderEventsAfter(ctx, restaurantID(c), after, int64(count+1))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the event stream"})
+			return
+		}
+		hasMore := len(events) > count
+		if hasMore {
+			events = events[:count]
+		}
+		var next *string
+		if len(events) > 0 {
+			next = &events[len(events)-1].Id
+		}
+		c.JSON(http.StatusOK, gin.H{"data": events, "next_cursor": next, "has_more": hasMore, "complete": complete})
+	}
+}
+
+// GetEventGroups lists the consumer groups reading the restaurant's event stream, lag is how
+// many events a group hasn't been handed yet
+func GetEventGroups() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		groups, err := helper.EventGroups(ctx, restaurantID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the consumer groups"})
+			return
+		}
+		data := []gin.H{}
+		for _, group := range groups {
+			data = append(data, gin.H{
+				"name":              group.Name,
+				"consumers":         group.Consumers,
+				"pending":           group.Pending,
+				"last_delivered_id": group.LastDeliveredID,
+				"lag":               group.Lag,
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{"data": data})
+	}
+}
+
+// CreateEventGroup adds a consumer group to the restaurant's event stream. from is where it
+// starts: 0 for every event still kept, $ (the default) for new events only, or a stream id
+func CreateEventGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Name string `json:"name"`
+			From string `json:"from"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		body.Name = strings.TrimSpace(body.Name)
+		if body.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+		if body.From == "" {
+			body.From = "$"
+		}
+		if !helper.IsStreamOffset(body.From) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be 0, $ or a stream id"})
+			return
+		}
+
+		err := helper.CreateEventGroup(ctx, restaurantID(c), body.Name, body.From)
+		switch {
+		case errors.Is(err, helper.ErrEventGroupExists):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "the consumer group was not created"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"name": body.Name, "from": body.From})
+	}
+}
+
+// ReplayEventGroup moves a consumer group back (or forward) to a stream id, the group is then
+// handed every event after it again
+func ReplayEventGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			From string `json:"from"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !helper.IsStreamOffset(body.From) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be 0, $ or a stream id"})
+			return
+		}
+
+		group := c.Param("group")
+		err := helper.SetEventGroupOffset(ctx, restaurantID(c), group, body.From)
+		switch {
+		case errors.Is(err, helper.ErrEventGroupNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "the consumer group was not moved"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"name": group, "from": body.From})
+	}
+}
@@ -0,0 +1,239 @@
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// pageQuery is one page of a list sorted by a field, either after the item a cursor points at
+// or, for clients that still page by number, after skipping Offset items. _id breaks ties so
+// items that share a sort value are neither skipped nor repeated
+type pageQuery struct {
+	Sort   string
+	Desc   bool
+	Limit  int
+	Offset int
+	// set when the client asked for a page by offset or number instead of a cursor
+	offsetMode bool
+	after      bson.M
+}
+
+// parsePageQuery reads sort (a field, - in front for descending), limit and either cursor or
+// offset/page. a cursor has to come from a page with the same sort. the food list's old
+// recordPerPage and startIndex still work as limit and offset
+func parsePageQuery(c *gin.Context, sortable []string, defaultSort string) (pageQuery, error) {
+	return parsePageQueryLimits(c, sortable, defaultSort, defaultPageLimit, maxPageLimit)
+}
+
+// parsePageQueryLimits is parsePageQuery for a list with its own default and largest page
+func parsePageQueryLimits(c *gin.Context, sortable []string, defaultSort string, defaultLimit, maxLimit int) (pageQuery, error) {
+	query := pageQuery{Limit: defaultLimit}
+
+	sort := c.DefaultQuery("sort", defaultSort)
+	query.Desc = strings.HasPrefix(sort, "-")
+	query.Sort = strings.TrimPrefix(sort, "-")
+	if !slices.Contains(sortable, query.Sort) {
+		return query, errors.New("sort must be one of " + strings.Join(sortable, ", ") + ", with - in front for descending")
+	}
+
+	limit, err := queryNumber(c, "limit", "recordPerPage")
+	if err != nil || (limit != nil && (*limit < 1 || *limit > maxLimit)) {
+		return query, fmt.Errorf("limit must be a number from 1 to %d", maxLimit)
+	}
+	if limit != nil {
+		query.Limit = *limit
+	}
+
+	offset, err := queryNumber(c, "offset", "startIndex")
+	if err != nil || (offset != nil && *offset < 0) {
+		return query, errors.New("offset must be a number from 0")
+	}
+	page, err := queryNumber(c, "page")
+	if err != nil || (page != nil && *page < 1) {
+		return query, errors.New("page must be a number from 1")
+	}
+	switch {
+	case offset != nil:
+		query.Offset, query.offsetMode = *offset, true
+	case page != nil:
+		query.Offset, query.offsetMode = (*page-1)*query.Limit, true
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		if query.offsetMode {
+			return query, errors.New("send either cursor or offset/page, not both")
+		}
+		after, err := decodeCursor(cursor)
+		if err != nil || after["sort"] != sort {
+			return query, errors.New("cursor is not valid for this list")
+		}
+		query.after = after
+	}
+	return query, nil
+}
+
+// queryNumber reads the first of the query parameters that is set, nil when none is
+func queryNumber(c *gin.Context, names ...string) (*int, error) {
+	for _, name := range names {
+		if value := c.Query(name); value != "" {
+			number, err := strconv.Atoi(value)
+			return &number, err
+		}
+	}
+	return nil, nil
+}
+
+func (q pageQuery) sortOrder() bson.D {
+	direction := 1
+	if q.Desc {
+		direction = -1
+	}
+	return bson.D{{Key: q.Sort, Value: direction}, {Key: "_id", Value: direction}}
+}
+
+// afterCursor matches what comes after the cursor in the sort order, nil without a cursor.
+// documents without the sort field sort as null, which is before every value, but $gt and $lt
+// never match null, so those documents are matched on their own
+func (q pageQuery) afterCursor() bson.M {
+	if q.after == nil {
+		return nil
+	}
+	beyond := "$gt"
+	if q.Desc {
+		beyond = "$lt"
+	}
+	value, id := q.after["value"], q.after["id"]
+	if value == nil {
+		after := bson.A{bson.M{q.Sort: nil, "_id": bson.M{beyond: id}}}
+		if !q.Desc {
+			after = append(after, bson.M{q.Sort: bson.M{"$ne": nil}})
+		}
+		return bson.M{"$or": after}
+	}
+	after := bson.A{
+		bson.M{q.Sort: bson.M{beyond: value}},
+		bson.M{q.Sort: value, "_id": bson.M{beyond: id}},
+	}
+	if q.Desc {
+		after = append(after, bson.M{q.Sort: nil})
+	}
+	return bson.M{"$or": after}
+}
+
+// apply narrows a filter to the page and returns the options that sort and limit it. one
+// extra item is fetched to tell whether there is a next page
+func (q pageQuery) apply(filter bson.M) (bson.M, *options.FindOptions) {
+	opts := options.Find().SetSort(q.sortOrder()).SetSkip(int64(q.Offset)).SetLimit(int64(q.Limit + 1))
+	if after := q.afterCursor(); after != nil {
+		return bson.M{"$and": bson.A{filter, after}}, opts
+	}
+	return filter, opts
+}
+
+// stages are apply for an aggregation, they go right after its $match
+func (q pageQuery) stages() mongo.Pipeline {
+	pipeline := mongo.Pipeline{}
+	if after := q.afterCursor(); after != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: after}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$sort", Value: q.sortOrder()}})
+	if q.Offset > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$skip", Value: q.Offset}})
+	}
+	return append(pipeline, bson.D{{Key: "$limit", Value: q.Limit + 1}})
+}
+
+// findPage lists one page of a collection with the list envelope every list endpoint answers
+// with. total_count is everything matching the filter, not just this page
+func findPage[T any](ctx context.Context, collection *mongo.Collection, filter bson.M, query pageQuery) (gin.H, error) {
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	pageFilter, opts := query.apply(filter)
+	cursor, err := collection.Find(ctx, pageFilter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []bson.Raw
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	return pageEnvelope[T](docs, total, query)
+}
+
+// pageEnvelope decodes a page of documents fetched with one extra, and works out the cursor
+// of the next page from the last one it keeps
+func pageEnvelope[T any](docs []bson.Raw, total int64, query pageQuery) (gin.H, error) {
+	hasMore := len(docs) > query.Limit
+	if hasMore {
+		docs = docs[:query.Limit]
+	}
+
+	var next *string
+	if hasMore && !query.offsetMode {
+		last := docs[len(docs)-1]
+		sort := query.Sort
+		if query.Desc {
+			sort = "-" + sort
+		}
+		// a document without the sort field is at null, see afterCursor
+		var value interface{}
+		if found := last.Lookup(query.Sort); !found.IsZero() {
+			value = found
+		}
+		cursor, err := encodeCursor(bson.D{
+			{Key: "sort", Value: sort},
+			{Key: "value", Value: value},
+			{Key: "id", Value: last.Lookup("_id")},
+		})
+		if err != nil {
+			return nil, err
+		}
+		next = &cursor
+	}
+
+	data := make([]T, 0, len(docs))
+	for _, doc := range docs {
+		var item T
+		if err := bson.Unmarshal(doc, &item); err != nil {
+			return nil, err
+		}
+		data = append(data, item)
+	}
+	return gin.H{"data": data, "total_count": total, "next_cursor": next, "has_more": hasMore}, nil
+}
+
+// cursors are opaque to clients, they are extended json so dates and ids keep their types
+func encodeCursor(position bson.D) (string, error) {
+	data, err := bson.MarshalExtJSON(position, true, false)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(cursor string) (bson.M, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	var position bson.M
+	err = bson.UnmarshalExtJSON(data, true, &position)
+	return position, err
+}
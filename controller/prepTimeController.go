@@ -0,0 +1,188 @@
+package controller
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"resturnat-management/helper"
+	"resturnat-management/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// prepTarget is how many minutes the kitchen has for a food, its own target wins over its
+// station's. nil means there is no target and the item is never late
+func prepTarget(food models.Food, setting models.Setting) *int {
+	if food.Prep_minutes != nil {
+		return food.Prep_minutes
+	}
+	if food.Station == nil {
+		return nil
+	}
+	if minutes, ok := setting.Station_prep_minutes[*food.Station]; ok {
+		return &minutes
+	}
+	return nil
+}
+
+// WatchPrepTimes checks for late order items until ctx is done, main runs it in the background
+func WatchPrepTimes(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			flagLateItems(ctx)
+		}
+	}
+}
+
+// flagLateItems alerts the kitchen about every item still queued or cooking past its prep
+// target. each item is only alerted once, and claiming it first means only one api instance
+// sends the alert when several run the checker
+func flagLateItems(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	filter := bson.M{
+		"kitchen_status":      bson.M{"$in": bson.A{models.KitchenQueued, models.KitchenCooking}},
+		"prep_target_minutes": bson.M{"$gt": 0},
+		"late_alerted_at":     nil,
+		"queued_at":           bson.M{"$ne": nil},
+		"$expr": bson.M{"$lt": bson.A{
+			bson.M{"$add": bson.A{"$queued_at", bson.M{"$multiply": bson.A{"$prep_target_minutes", 60 * 1000}}}},
+			now,
+		}},
+	}
+
+	var lateItems []models.OrderItem
+	cursor, err := orderItemCollection.Find(ctx, filter)
+	if err == nil {
+		err = cursor.All(ctx, &lateItems)
+	}
+	if err != nil {
+		log.Printf("late order items could not be checked: %v", err)
+		return
+	}
+
+	for _, orderItem := range lateItems {
+		claim := bson.M{"order_item_id": orderItem.OrderItem_id, "late_alerted_at": nil}
+		var claimed bool
+		err := writeWithEvents(ctx, func(sc mongo.SessionContext) ([]models.OutboxEvent, error) {
+			// a transaction that is retried runs this again
+			claimed = false
+			result, err := orderItemCollection.UpdateOne(sc, claim, bson.M{"$set": bson.M{"late_alerted_at": now}})
+			if err != nil || result.ModifiedCount == 0 {
+				return nil, err
+			}
+			claimed = true
+			orderItem.Late_alerted_at = &now
+			return []models.OutboxEvent{orderItemEvent(models.EventOrderItemLate, orderItem)}, nil
+		})
+		if err != nil {
+			log.Printf("late order item %s was not flagged: %v", orderItem.OrderItem_id, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		publishItemEvent(ctx, helper.KitchenItemLate, orderItem)
+	}
+}
+
+// GetPrepTimeReport averages how long items took from queued to ready, per food and per hour
+// of the day they were ordered. hours are in the tz query parameter's time zone, UTC by default
+func GetPrepTimeReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		timezone := c.DefaultQuery("tz", "UTC")
+		if _, err := time.LoadLocation(timezone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown time zone " + timezone})
+			return
+		}
+		queuedAt, err := parseQueryRange(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		match := tenantFilter(c, bson.M{
+			"prep_seconds":   bson.M{"$ne": nil},
+			"kitchen_status": bson.M{"$ne": models.KitchenVoided},
+		})
+		if queuedAt != nil {
+			match["queued_at"] = queuedAt
+		}
+
+		late := bson.M{"$and": bson.A{
+			bson.M{"$gt": bson.A{"$prep_target_minutes", 0}},
+			bson.M{"$gt": bson.A{"$prep_seconds", bson.M{"$multiply": bson.A{"$prep_target_minutes", 60}}}},
+		}}
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: match}},
+			{{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: bson.D{
+					{Key: "food_id", Value: "$food_id"},
+					{Key: "hour", Value: bson.M{"$hour": bson.M{"date": "$queued_at", "timezone": timezone}}},
+				}},
+				{Key: "items", Value: bson.M{"$sum": 1}},
+				{Key: "avg_prep_seconds", Value: bson.M{"$avg": "$prep_seconds"}},
+				{Key: "max_prep_seconds", Value: bson.M{"$max": "$prep_seconds"}},
+				{Key: "late", Value: bson.M{"$sum": bson.M{"$cond": bson.A{late, 1, 0}}}},
+			}}},
+			{{Key: "$sort", Value: bson.D{{Key: "_id.food_id", Value: 1}, {Key: "_id.hour", Value: 1}}}},
+		}
+
+		var groups []struct {
+			Id struct {
+				Food_id string `bson:"food_id"`
+				Hour    int    `bson:"hour"`
+			} `bson:"_id"`
+			Items            int     `bson:"items"`
+			Avg_prep_seconds float64 `bson:"avg_prep_seconds"`
+			Max_prep_seconds int64   `bson:"max_prep_seconds"`
+			Late             int     `bson:"late"`
+		}
+		cursor, err := orderItemCollection.Aggregate(ctx, pipeline)
+		if err == nil {
+			err = cursor.All(ctx, &groups)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while building the report"})
+			return
+		}
+
+		foodIds := bson.A{}
+		for _, group := range groups {
+			foodIds = append(foodIds, group.Id.Food_id)
+		}
+		names, err := foodNames(ctx, c, foodIds)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the foods"})
+			return
+		}
+
+		rows := []gin.H{}
+		for _, group := range groups {
+			rows = append(rows, gin.H{
+				"food_id":          group.Id.Food_id,
+				"food_name":        names[group.Id.Food_id],
+				"hour":             group.Id.Hour,
+				"items":            group.Items,
+				"avg_prep_seconds": math.Round(group.Avg_prep_seconds),
+				"max_prep_seconds": group.Max_prep_seconds,
+				"late":             group.Late,
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{"timezone": timezone, "rows": rows})
+	}
+}
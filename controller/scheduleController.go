@@ -0,0 +1,259 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"resturnat-management/database"
+	"resturnat-management/helper"
+	"resturnat-management/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// used when a restaurant hasn't set its own
+const (
+	defaultLeadMinutes = 30
+	defaultSlotMinutes = 15
+	// the furthest ahead of its time a pre-order can be released
+	maxLeadMinutes = 24 * 60
+)
+
+var scheduleSlotCollection *mongo.Collection = database.OpenCollection(database.Client, "scheduleSlot")
+
+// the scheduler shows up as this actor in the audit log and status history
+const schedulerActor = "scheduler"
+
+func scheduleLead(setting models.Setting) time.Duration {
+	if setting.Schedule_lead_minutes != nil {
+		return time.Duration(*setting.Schedule_lead_minutes) * time.Minute
+	}
+	return defaultLeadMinutes * time.Minute
+}
+
+func scheduleSlot(setting models.Setting) time.Duration {
+	if setting.Schedule_slot_minutes != nil {
+		return time.Duration(*setting.Schedule_slot_minutes) * time.Minute
+	}
+	return defaultSlotMinutes * time.Minute
+}
+
+// scheduleOrder makes a new order a pre-order for its Scheduled_for time. the status code says
+// why an order was refused. when the restaurant limits its slots the order gets the slot it
+// falls in, the place in it is taken with takeSlotPlace in the transaction that inserts it
+func scheduleOrder(ctx context.Context, order *models.Order, actor string) (int, error) {
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	if !order.Scheduled_for.After(now) {
+		return http.StatusBadRequest, errors.New("scheduled_for must be in the future")
+	}
+
+	setting, err := loadSettings(ctx, order.Restaurant_id)
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("error occured while fetching the settings")
+	}
+	order.Slot_id = nil
+	if capacity := setting.Schedule_slot_capacity; capacity != nil && *capacity > 0 {
+		slotId := slotIdOf(order.Restaurant_id, order.Scheduled_for.Truncate(scheduleSlot(setting)), scheduleSlot(setting))
+		order.Slot_id = &slotId
+	}
+
+	if order.Promised_at == nil {
+		order.Promised_at = order.Scheduled_for
+	}
+	order.Order_status = models.OrderScheduled
+	order.Status_history = []models.OrderStatusChange{{Status: models.OrderScheduled, Changed_at: now, Changed_by: actor}}
+	return 0, nil
+}
+
+var errSlotFull = errors.New("the time slot is fully booked")
+
+func slotIdOf(restaurantId string, start time.Time, slot time.Duration) string {
+	return fmt.Sprintf("%s:%d:%d", restaurantId, start.Unix(), int(slot.Minutes()))
+}
+
+// takeSlotPlace takes a place in the pre-order's slot inside the transaction that inserts the
+// order, so two orders can't both get the last one. the counter only goes up while it is below
+// the capacity. a slot's counter is made by its first booking, starting from the pre-orders
+// that were already in the slot. run it before the order itself is inserted
+func takeSlotPlace(sc mongo.SessionContext, order models.Order) error {
+	if order.Slot_id == nil {
+		return nil
+	}
+	setting, err := loadSettings(sc, order.Restaurant_id)
+	if err != nil {
+		return err
+	}
+	if setting.Schedule_slot_capacity == nil || *setting.Schedule_slot_capacity <= 0 {
+		return nil
+	}
+	capacity := *setting.Schedule_slot_capacity
+
+	result, err := scheduleSlotCollection.UpdateOne(sc,
+		bson.M{"slot_id": *order.Slot_id, "count": bson.M{"$lt": capacity}},
+		bson.M{"$inc": bson.M{"count": 1}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 1 {
+		return nil
+	}
+	exists, err := scheduleSlotCollection.CountDocuments(sc, bson.M{"slot_id": *order.Slot_id})
+	if err != nil {
+		return err
+	}
+	if exists > 0 {
+		return errSlotFull
+	}
+
+	slot := scheduleSlot(setting)
+	start := order.Scheduled_for.Truncate(slot)
+	taken, err := orderCollection.CountDocuments(sc, bson.M{
+		"restaurant_id": order.Restaurant_id,
+		"scheduled_for": bson.M{"$gte": start, "$lt": start.Add(slot)},
+		"order_status":  bson.M{"$nin": bson.A{models.OrderCancelled, models.OrderMerged}},
+	})
+	if err != nil {
+		return err
+	}
+	if taken >= int64(capacity) {
+		return errSlotFull
+	}
+	// slot_id is unique, a first booking racing this one makes one of the transactions retry
+	_, err = scheduleSlotCollection.InsertOne(sc, models.ScheduleSlot{
+		ID:            primitive.NewObjectID(),
+		Slot_id:       *order.Slot_id,
+		Restaurant_id: order.Restaurant_id,
+		Start:         start,
+		Minutes:       int(slot.Minutes()),
+		Count:         int(taken) + 1,
+	})
+	return err
+}
+
+// releaseSlotPlace gives back the place a pre-order held, in the transaction that cancels or
+// merges it
+func releaseSlotPlace(sc mongo.SessionContext, order models.Order) error {
+	if order.Slot_id == nil {
+		return nil
+	}
+	_, err := scheduleSlotCollection.UpdateOne(sc,
+		bson.M{"slot_id": *order.Slot_id, "count": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"count": -1}},
+	)
+	return err
+}
+
+// WatchScheduledOrders places pre-orders that are due in the kitchen until ctx is done, main
+// runs it in the background
+func WatchScheduledOrders(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			releaseDueOrders(ctx)
+		}
+	}
+}
+
+// releaseDueOrders places every pre-order within its restaurant's lead time. the status change
+// only matches while the order is still SCHEDULED, so when several api instances run the
+// scheduler only one of them releases an order
+func releaseDueOrders(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var orders []models.Order
+	cursor, err := orderCollection.Find(ctx, bson.M{
+		"order_status":  models.OrderScheduled,
+		"scheduled_for": bson.M{"$lte": now.Add(maxLeadMinutes * time.Minute)},
+	})
+	if err == nil {
+		err = cursor.All(ctx, &orders)
+	}
+	if err != nil {
+		log.Printf("scheduled orders could not be checked: %v", err)
+		return
+	}
+
+	settings := map[string]models.Setting{}
+	for _, order := range orders {
+		setting, ok := settings[order.Restaurant_id]
+		if !ok {
+			if setting, err = loadSettings(ctx, order.Restaurant_id); err != nil {
+				log.Printf("settings of restaurant %s could not be loaded: %v", order.Restaurant_id, err)
+				continue
+			}
+			settings[order.Restaurant_id] = setting
+		}
+		if now.Before(order.Scheduled_for.Add(-scheduleLead(setting))) {
+			continue
+		}
+
+		before, after, err := changeOrderStatus(ctx, order.Restaurant_id, order.Order_id, models.OrderPlaced, schedulerActor, nil)
+		if errors.Is(err, errOrderChanged) {
+			continue
+		}
+		if err != nil {
+			log.Printf("scheduled order %s was not placed: %v", order.Order_id, err)
+			continue
+		}
+		recordSystemAudit(ctx, order.Restaurant_id, schedulerActor, "order", order.Order_id, AuditUpdate, before, after)
+		held, fired := fireFirstCourse(ctx, after)
+		for i := range fired {
+			recordSystemAudit(ctx, order.Restaurant_id, schedulerActor, "orderItem", fired[i].OrderItem_id, AuditUpdate, held[i], fired[i])
+		}
+	}
+}
+
+// fireFirstCourse sends the lowest course still held on a placed pre-order to the kitchen.
+// it returns the items that were fired as they were before and after, for the audit log
+func fireFirstCourse(ctx context.Context, order models.Order) (held, fired []models.OrderItem) {
+	var orderItems []models.OrderItem
+	cursor, err := orderItemCollection.Find(ctx, bson.M{
+		"order_id":       order.Order_id,
+		"restaurant_id":  order.Restaurant_id,
+		"fired_at":       nil,
+		"kitchen_status": bson.M{"$ne": models.KitchenVoided},
+	})
+	if err == nil {
+		err = cursor.All(ctx, &orderItems)
+	}
+	if err != nil {
+		log.Printf("held items of order %s could not be fetched: %v", order.Order_id, err)
+		return nil, nil
+	}
+
+	firstCourse := 0
+	for _, orderItem := range orderItems {
+		if course := courseOf(orderItem); firstCourse == 0 || course < firstCourse {
+			firstCourse = course
+		}
+	}
+	for _, orderItem := range orderItems {
+		if courseOf(orderItem) != firstCourse {
+			continue
+		}
+		after, ok, err := fireOrderItem(ctx, orderItem)
+		if err != nil {
+			log.Printf("order item %s was not fired: %v", orderItem.OrderItem_id, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		publishItemEvent(ctx, helper.KitchenItemCreated, after)
+		held = append(held, orderItem)
+		fired = append(fired, after)
+	}
+	return held, fired
+}
@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"resturnat-management/database"
+	"resturnat-management/helper"
+	"resturnat-management/models"
+	"resturnat-management/pricing"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var settingCollection *mongo.Collection = database.OpenCollection(database.Client, "setting")
+
+// loadSettings returns the restaurant's settings, or the defaults when none have been saved
+func loadSettings(ctx context.Context, restaurantId string) (models.Setting, error) {
+	var setting models.Setting
+	err := settingCollection.FindOne(ctx, bson.M{"restaurant_id": restaurantId}).Decode(&setting)
+	if err == mongo.ErrNoDocuments {
+		return models.Setting{Restaurant_id: restaurantId, Mfa_required_roles: []string{}}, nil
+	}
+	return setting, err
+}
+
+func twoFactorRequired(setting models.Setting, role string) bool {
+	return slices.Contains(setting.Mfa_required_roles, role)
+}
+
+func GetSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		setting, err := loadSettings(ctx, restaurantID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the settings"})
+			return
+		}
+		c.JSON(http.StatusOK, setting)
+	}
+}
+
+func UpdateSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var setting models.Setting
+
+		if err := c.BindJSON(&setting); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var updateObj primitive.D
+
+		if setting.Mfa_required_roles != nil {
+			for _, role := range setting.Mfa_required_roles {
+				if !helper.IsValidRole(role) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "mfa_required_roles may only contain ADMIN, MANAGER or STAFF"})
+					return
+				}
+			}
+			updateObj = append(updateObj, bson.E{Key: "mfa_required_roles", Value: setting.Mfa_required_roles})
+		}
+
+		// the pricing fields are checked together with what is already saved, so a
+		// partial update can't leave the policy invalid
+		current, err := loadSettings(ctx, restaurantID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the settings"})
+			return
+		}
+		// station targets are replaced as a whole
+		if setting.Station_prep_minutes != nil {
+			for station, minutes := range setting.Station_prep_minutes {
+				if !models.IsValidStation(station) || minutes < 1 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "station_prep_minutes maps grill, fryer, bar or dessert to at least 1 minute"})
+					return
+				}
+			}
+			updateObj = append(updateObj, bson.E{Key: "station_prep_minutes", Value: setting.Station_prep_minutes})
+		}
+		if setting.Schedule_lead_minutes != nil {
+			if *setting.Schedule_lead_minutes < 0 || *setting.Schedule_lead_minutes > maxLeadMinutes {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "schedule_lead_minutes must be between 0 and 1440"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "schedule_lead_minutes", Value: setting.Schedule_lead_minutes})
+		}
+		if setting.Schedule_slot_minutes != nil {
+			if *setting.Schedule_slot_minutes < 1 || *setting.Schedule_slot_minutes > 24*60 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "schedule_slot_minutes must be between 1 and 1440"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "schedule_slot_minutes", Value: setting.Schedule_slot_minutes})
+		}
+		if setting.Schedule_slot_capacity != nil {
+			if *setting.Schedule_slot_capacity < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "schedule_slot_capacity can't be negative"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "schedule_slot_capacity", Value: setting.Schedule_slot_capacity})
+		}
+		if setting.Tax_mode != "" {
+			current.Tax_mode = setting.Tax_mode
+			updateObj = append(updateObj, bson.E{Key: "tax_mode", Value: setting.Tax_mode})
+		}
+		if setting.Tax_rate != nil {
+			current.Tax_rate = setting.Tax_rate
+			updateObj = append(updateObj, bson.E{Key: "tax_rate", Value: setting.Tax_rate})
+		}
+		if setting.Service_charge_rate != nil {
+			current.Service_charge_rate = setting.Service_charge_rate
+			updateObj = append(updateObj, bson.E{Key: "service_charge_rate", Value: setting.Service_charge_rate})
+		}
+		if setting.Service_charge_tax_rate != nil {
+			current.Service_charge_tax_rate = setting.Service_charge_tax_rate
+			updateObj = append(updateObj, bson.E{Key: "service_charge_tax_rate", Value: setting.Service_charge_tax_rate})
+		}
+		if setting.Rounding_increment != nil {
+			current.Rounding_increment = setting.Rounding_increment
+			updateObj = append(updateObj, bson.E{Key: "rounding_increment", Value: setting.Rounding_increment})
+		}
+		if err := pricing.CheckConfig(pricingConfig(current)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: now})
+
+		upsert := true
+		opt := options.UpdateOptions{
+			Upsert: &upsert,
+		}
+
+		filter := tenantFilter(c, bson.M{})
+		before := snapshot(ctx, settingCollection, filter)
+		_, err = settingCollection.UpdateOne(ctx, filter, bson.D{
+			{Key: "$set", Value: updateObj},
+			{Key: "$setOnInsert", Value: bson.D{
+				{Key: "_id", Value: primitive.NewObjectID()},
+				{Key: "created_at", Value: now},
+			}},
+		}, &opt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "settings were not updated"})
+			return
+		}
+		after := snapshot(ctx, settingCollection, filter)
+		action := AuditUpdate
+		if before == nil {
+			action = AuditCreate
+		}
+		recordAudit(ctx, c, "setting", restaurantID(c), action, before, after)
+
+		updated, err := loadSettings(ctx, restaurantID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the settings"})
+			return
+		}
+		c.JSON(http.StatusOK, updated)
+	}
+}
@@ -37,12 +37,14 @@ func CreateTable() gin.HandlerFunc {
 
 		table.ID = primitive.NewObjectID()
 		table.Table_id = table.ID.Hex()
+		table.Restaurant_id = restaurantID(c)
 
 		result, insertErr := tableCollection.InsertOne(ctx, table)
 		if insertErr != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Table was not created"})
 			return
 		}
+		recordAudit(ctx, c, "table", table.Table_id, AuditCreate, nil, table)
 		defer cancel()
 		c.JSON(http.StatusOK, result)
 	}
@@ -54,7 +56,7 @@ func GetTable() gin.HandlerFunc {
 		tableId := c.Param("table_id")
 		var table models.Table
 
-		err := tableCollection.FindOne(ctx, bson.M{"table_id": tableId}).Decode(&table)
+		err := tableCollection.FindOne(ctx, tenantFilter(c, bson.M{"table_id": tableId})).Decode(&table)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, bson.M{"error": "Error occured while fetching the table"})
 			return
@@ -67,21 +69,19 @@ func GetTable() gin.HandlerFunc {
 func GetAllTables() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-
-		result, err := tableCollection.Find(context.TODO(), bson.M{})
-
 		defer cancel()
 
+		query, err := parsePageQuery(c, []string{"table_number", "created_at"}, "table_number")
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching tables"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
-		var allTables []bson.M
-
-		if err = result.All(ctx, &allTables); err != nil {
+		page, err := findPage[bson.M](ctx, tableCollection, tenantFilter(c, bson.M{}), query)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching tables"})
 			return
 		}
-		c.JSON(http.StatusOK, allTables)
+		c.JSON(http.StatusOK, page)
 	}
 }
 
@@ -89,7 +89,7 @@ func UpdateTable() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
-		tableId := c.Param("table_id")
+		tableId := c.Param("id")
 		var table models.Table
 		if err := c.BindJSON(&table); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -110,11 +110,12 @@ func UpdateTable() gin.HandlerFunc {
 		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: table.Updated_at})
 
 		upsert := true
-		filter := bson.M{"table_id": tableId}
+		filter := tenantFilter(c, bson.M{"table_id": tableId})
 		opt := options.UpdateOptions{
 			Upsert: &upsert,
 		}
 
+		before := snapshot(ctx, tableCollection, filter)
 		result, err := tableCollection.UpdateOne(
 			ctx,
 			filter,
@@ -127,6 +128,7 @@ func UpdateTable() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in updating the table"})
 			return
 		}
+		recordAudit(ctx, c, "table", tableId, AuditUpdate, before, snapshot(ctx, tableCollection, filter))
 		defer cancel()
 		c.JSON(http.StatusOK, result)
 	}
@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"resturnat-management/helper"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// every document belongs to a restaurant (branch), the id comes from the caller's token
+// or api key and is set by the auth middleware
+func restaurantID(c *gin.Context) string {
+	return c.GetString("restaurant_id")
+}
+
+// callerHasPermission runs the Authorize check inside a handler, for when only part
+// of a request needs the permission
+func callerHasPermission(c *gin.Context, permission string) bool {
+	return helper.HasPermission(c.GetString("auth_type"), c.GetString("role"), c.GetStringSlice("scopes"), permission)
+}
+
+// tenantFilter scopes a query filter to the caller's restaurant so one branch can
+// never read or change another branch's documents
+func tenantFilter(c *gin.Context, filter bson.M) bson.M {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	filter["restaurant_id"] = restaurantID(c)
+	return filter
+}
+
+// tenantLookup is a $lookup stage that only joins documents of the same restaurant
+func tenantLookup(from, localField, foreignField, as, restaurantId string) bson.D {
+	return scopedLookup(from, localField, foreignField, as, bson.A{restaurantId})
+}
+
+func scopedLookup(from, localField, foreignField, as string, restaurantIds bson.A) bson.D {
+	return bson.D{{
+		Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: from},
+			{Key: "let", Value: bson.D{{Key: "local", Value: "$" + localField}}},
+			{Key: "pipeline", Value: bson.A{
+				bson.D{{Key: "$match", Value: bson.D{
+					{Key: "$expr", Value: bson.D{{Key: "$and", Value: bson.A{
+						bson.D{{Key: "$eq", Value: bson.A{"$" + foreignField, "$$local"}}},
+						bson.D{{Key: "$in", Value: bson.A{"$restaurant_id", restaurantIds}}},
+					}}}},
+				}}},
+			}},
+			{Key: "as", Value: as},
+		},
+	}}
+}
@@ -0,0 +1,28 @@
+package controller
+
+import (
+	"context"
+	"resturnat-management/database"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// withTransaction runs fn inside a mongo session transaction, everything fn writes through
+// the session context is committed together or not at all. transactions need mongo to run
+// as a replica set, a single node replica set is enough. called with the session context of
+// a transaction already running, fn just becomes part of it
+func withTransaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error {
+	if sc, ok := ctx.(mongo.SessionContext); ok {
+		return fn(sc)
+	}
+	session, err := database.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
@@ -0,0 +1,285 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"resturnat-management/helper"
+	"resturnat-management/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	recoveryCodeCount = 10
+	// wrong codes in a row before the second login step is locked, and for how long
+	maxTwoFactorAttempts = 5
+	twoFactorLockout     = 15 * time.Minute
+)
+
+type twoFactorCode struct {
+	Code string `json:"code"`
+}
+
+// EnrollTwoFactor starts totp enrollment, the returned uri is shown to the user as a QR code.
+// nothing changes at login until the first code is confirmed with VerifyTwoFactor
+func EnrollTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := tenantFilter(c, bson.M{"user_id": c.GetString("uid")})
+		var user models.User
+		if err := userCollection.FindOne(ctx, filter).Decode(&user); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if user.Totp_enabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+			return
+		}
+
+		secret, err := helper.GenerateTotpSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while generating the secret"})
+			return
+		}
+
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		_, err = userCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"totp_secret": secret, "updated_at": updatedAt}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "two-factor enrollment was not started"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"secret":           secret,
+			"provisioning_uri": helper.TotpProvisioningURI(secret, *user.Email),
+		})
+	}
+}
+
+// VerifyTwoFactor confirms enrollment with a first code and hands out the recovery codes.
+// when reached with an enrollment challenge from Login it also completes that login
+func VerifyTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body twoFactorCode
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		filter := tenantFilter(c, bson.M{"user_id": c.GetString("uid")})
+		var user models.User
+		if err := userCollection.FindOne(ctx, filter).Decode(&user); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if user.Totp_enabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+			return
+		}
+		if user.Totp_secret == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "start enrollment first"})
+			return
+		}
+
+		step, ok := helper.ValidateTotp(*user.Totp_secret, body.Code, user.Totp_last_step, time.Now())
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid two-factor code"})
+			return
+		}
+
+		codes, hashes, err := helper.GenerateRecoveryCodes(recoveryCodeCount)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while generating recovery codes"})
+			return
+		}
+
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		before := snapshot(ctx, userCollection, filter)
+		_, err = userCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+			"totp_enabled":   true,
+			"totp_last_step": step,
+			"recovery_codes": hashes,
+			"updated_at":     updatedAt,
+		}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "two-factor authentication was not enabled"})
+			return
+		}
+		recordAudit(ctx, c, "user", user.User_id, AuditUpdate, before, snapshot(ctx, userCollection, filter))
+
+		// the recovery codes are only ever shown here
+		response := gin.H{"message": "two-factor authentication enabled", "recovery_codes": codes}
+		if c.GetBool("enrollment_challenge") {
+			token, refreshToken, err := issueTokens(user)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error generating tokens"})
+				return
+			}
+			response["token"] = token
+			response["refresh_token"] = refreshToken
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+func DisableTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body twoFactorCode
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		filter := tenantFilter(c, bson.M{"user_id": c.GetString("uid")})
+		var user models.User
+		if err := userCollection.FindOne(ctx, filter).Decode(&user); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if !user.Totp_enabled || user.Totp_secret == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is not enabled"})
+			return
+		}
+
+		setting, err := loadSettings(ctx, user.Restaurant_id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking the two-factor policy"})
+			return
+		}
+		if twoFactorRequired(setting, userRole(user)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "two-factor authentication is mandatory for your role"})
+			return
+		}
+
+		if _, ok := helper.ValidateTotp(*user.Totp_secret, body.Code, user.Totp_last_step, time.Now()); !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid two-factor code"})
+			return
+		}
+
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		before := snapshot(ctx, userCollection, filter)
+		_, err = userCollection.UpdateOne(ctx, filter, bson.M{
+			"$set":   bson.M{"totp_enabled": false, "updated_at": updatedAt},
+			"$unset": bson.M{"totp_secret": "", "totp_last_step": "", "recovery_codes": ""},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "two-factor authentication was not disabled"})
+			return
+		}
+		recordAudit(ctx, c, "user", user.User_id, AuditUpdate, before, snapshot(ctx, userCollection, filter))
+
+		c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+	}
+}
+
+// LoginTwoFactor is the second step of Login, it trades the challenge token and a totp
+// or recovery code for the usual token pair
+func LoginTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Challenge_token string `json:"challenge_token"`
+			Code            string `json:"code"`
+			Recovery_code   string `json:"recovery_code"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		claims, msg := helper.ValidateToken(body.Challenge_token)
+		if msg != "" || claims.Purpose != helper.ChallengeTwoFactor {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired challenge, log in again"})
+			return
+		}
+
+		filter := bson.M{"user_id": claims.Uid, "restaurant_id": claims.Restaurant_id}
+		var user models.User
+		if err := userCollection.FindOne(ctx, filter).Decode(&user); err != nil || !user.Totp_enabled || user.Totp_secret == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired challenge, log in again"})
+			return
+		}
+		if user.Totp_locked_until != nil && user.Totp_locked_until.After(time.Now()) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many wrong codes, try again later"})
+			return
+		}
+
+		// the code is used up by the same update that accepts it, so two logins racing with
+		// one code can't both get through
+		guard := bson.M{"user_id": user.User_id, "restaurant_id": user.Restaurant_id}
+		var update bson.M
+		var refused string
+		if body.Recovery_code != "" {
+			refused = "invalid recovery code"
+			if index := helper.MatchRecoveryCode(user.Recovery_codes, body.Recovery_code); index >= 0 {
+				// every recovery code works once
+				guard["recovery_codes"] = user.Recovery_codes[index]
+				update = bson.M{
+					"$pull": bson.M{"recovery_codes": user.Recovery_codes[index]},
+					"$set":  bson.M{"totp_failed_attempts": 0},
+				}
+			}
+		} else {
+			refused = "invalid two-factor code"
+			if step, ok := helper.ValidateTotp(*user.Totp_secret, body.Code, user.Totp_last_step, time.Now()); ok {
+				guard["$or"] = bson.A{bson.M{"totp_last_step": nil}, bson.M{"totp_last_step": bson.M{"$lt": step}}}
+				update = bson.M{"$set": bson.M{"totp_last_step": step, "totp_failed_attempts": 0}}
+			}
+		}
+
+		accepted := false
+		if update != nil {
+			result, err := userCollection.UpdateOne(ctx, guard, update)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while logging in"})
+				return
+			}
+			accepted = result.ModifiedCount == 1
+		}
+		if !accepted {
+			if err := countFailedTwoFactor(ctx, filter); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while logging in"})
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": refused})
+			return
+		}
+
+		token, refreshToken, err := issueTokens(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error generating tokens"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"token": token, "refresh_token": refreshToken})
+	}
+}
+
+// countFailedTwoFactor counts a wrong code against the user. after maxTwoFactorAttempts of them
+// in a row the second step is locked for twoFactorLockout, which keeps the code space from being
+// worked through with one challenge token
+func countFailedTwoFactor(ctx context.Context, filter bson.M) error {
+	if _, err := userCollection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"totp_failed_attempts": 1}}); err != nil {
+		return err
+	}
+	locked := bson.M{"totp_failed_attempts": bson.M{"$gte": maxTwoFactorAttempts}}
+	for key, value := range filter {
+		locked[key] = value
+	}
+	_, err := userCollection.UpdateOne(ctx, locked, bson.M{"$set": bson.M{
+		"totp_failed_attempts": 0,
+		"totp_locked_until":    time.Now().Add(twoFactorLockout),
+	}})
+	return err
+}
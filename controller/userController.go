@@ -2,8 +2,11 @@ package controller
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"log"
 	"net/http"
+	"os"
 	"resturnat-management/database"
 	helper "resturnat-management/helper"
 
@@ -26,17 +29,17 @@ func GetUser() gin.HandlerFunc {
 
 		defer cancel()
 
-		userID := c.Param("user_id")
+		userID := c.Param("id")
 
 		var user models.User
 
-		err := userCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
+		err := userCollection.FindOne(ctx, tenantFilter(c, bson.M{"user_id": userID})).Decode(&user)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "User not found"})
 			return
 		}
 
-		c.JSON(http.StatusOK, user)
+		c.JSON(http.StatusOK, user.Public())
 
 	}
 }
@@ -50,11 +53,22 @@ func GetUser() gin.HandlerFunc {
 // 	}
 // }
 
+// Signup opens a new restaurant together with its first account, which becomes its admin.
+// the route is public, so it only works with the deployment's BOOTSTRAP_SECRET in the
+// X-Bootstrap-Secret header, and the restaurant id is made here instead of taken from the body
 func Signup() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
+
+		secret := os.Getenv("BOOTSTRAP_SECRET")
+		provided := c.Request.Header.Get("X-Bootstrap-Secret")
+		if secret == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(secret)) != 1 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "opening a restaurant needs the bootstrap secret"})
+			return
+		}
+
 		var user models.User
 
 		// binding the data coming from the request to the user model struct so that go can understand it
@@ -62,62 +76,89 @@ func Signup() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		user.Restaurant_id = primitive.NewObjectID().Hex()
 
-		// validating
-		valideteErr := validate.Struct(user)
-		if valideteErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": valideteErr.Error()})
-			return
-		}
-
-		// checking if the email already exists
-		count, err := userCollection.CountDocuments(ctx, bson.M{"email": user.Email})
-		defer cancel()
+		status, err := createUser(ctx, &user, helper.RoleAdmin)
 		if err != nil {
-			log.Panic(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking for the email"})
+			c.JSON(status, gin.H{"error": err.Error()})
 			return
 		}
 
-		// hashing the password
-		password := HashPassword(*user.Password)
-		user.Password = &password
+		// signup is unauthenticated, the new user is the actor of their own creation
+		c.Set("uid", user.User_id)
+		c.Set("restaurant_id", user.Restaurant_id)
+		recordAudit(ctx, c, "user", user.User_id, AuditCreate, nil, user.Public())
 
-		// checking if the email already exists
-		if count > 0 {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "this email already exists"})
+		c.JSON(http.StatusOK, gin.H{"token": *user.Token, "refreshToken": *user.Refresh_Token, "restaurant_id": user.Restaurant_id, "user_id": user.User_id})
+	}
+}
+
+// CreateUser adds an account to the caller's restaurant. it starts as staff, an admin promotes
+// it with UpdateUserRole, and the new user logs in with the password that was set here
+func CreateUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var user models.User
+		if err := c.BindJSON(&user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		user.Restaurant_id = restaurantID(c)
 
-		// if the user is new then create a new user
-		user.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		user.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		user.ID = primitive.NewObjectID()
-		user.User_id = user.ID.Hex()
-
-		// generate tokens
-		token, refreshtoken, err := helper.GenerateAllTokens(*user.Email, *user.FirstName, *user.LastName, user.User_id)
+		status, err := createUser(ctx, &user, helper.RoleStaff)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error generating tokens"})
+			c.JSON(status, gin.H{"error": err.Error()})
 			return
 		}
+		recordAudit(ctx, c, "user", user.User_id, AuditCreate, nil, user.Public())
 
-		user.Token = &token
-		user.Refresh_Token = &refreshtoken
+		c.JSON(http.StatusCreated, user.Public())
+	}
+}
 
-		// if all ok then create a new user
-		resultInsertNumber, insetErr := userCollection.InsertOne(ctx, user)
-		if insetErr != nil {
-			msg := "user item was not created"
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-			return
-		}
+// createUser validates and stores a new account of user.Restaurant_id with the given role. the
+// status code says why a user was refused
+func createUser(ctx context.Context, user *models.User, role string) (int, error) {
+	// validating
+	if err := validate.Struct(user); err != nil {
+		return http.StatusBadRequest, err
+	}
 
-		defer cancel()
+	// checking if the email already exists
+	count, err := userCollection.CountDocuments(ctx, bson.M{"email": user.Email})
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("error occured while checking for the email")
+	}
+	if count > 0 {
+		return http.StatusInternalServerError, errors.New("this email already exists")
+	}
+
+	// hashing the password
+	password := HashPassword(*user.Password)
+	user.Password = &password
+	user.Role = &role
+	// two-factor is turned on by enrolling, never by the request that makes the account
+	user.Totp_enabled = false
+
+	user.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	user.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	user.ID = primitive.NewObjectID()
+	user.User_id = user.ID.Hex()
 
-		c.JSON(http.StatusOK, resultInsertNumber)
-		c.JSON(http.StatusOK, gin.H{"token": token, "refreshToken": refreshtoken})
+	// generate tokens
+	token, refreshtoken, err := helper.GenerateAllTokens(*user.Email, *user.FirstName, *user.LastName, user.User_id, *user.Role, user.Restaurant_id)
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("error generating tokens")
 	}
+	user.Token = &token
+	user.Refresh_Token = &refreshtoken
+
+	if _, err := userCollection.InsertOne(ctx, user); err != nil {
+		return http.StatusInternalServerError, errors.New("user item was not created")
+	}
+	return http.StatusOK, nil
 }
 
 func Login() gin.HandlerFunc {
@@ -148,14 +189,101 @@ func Login() gin.HandlerFunc {
 			return
 		}
 
-		// generate tokens
-		token, refreshToken, _ := helper.GenerateAllTokens(*foundUser.Email, *foundUser.FirstName, *foundUser.LastName, foundUser.User_id)
-		helper.UpdateAllTokens(token, refreshToken, foundUser.User_id)
+		// with two-factor on, the password only earns a challenge token for the second step
+		if foundUser.Totp_enabled {
+			challenge, err := helper.GenerateChallengeToken(foundUser.User_id, foundUser.Restaurant_id, helper.ChallengeTwoFactor)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error generating tokens"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"two_factor_required": true, "challenge_token": challenge})
+			return
+		}
 
+		setting, err := loadSettings(ctx, foundUser.Restaurant_id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking the two-factor policy"})
+			return
+		}
+		if twoFactorRequired(setting, userRole(foundUser)) {
+			challenge, err := helper.GenerateChallengeToken(foundUser.User_id, foundUser.Restaurant_id, helper.ChallengeEnrollment)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error generating tokens"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"two_factor_enrollment_required": true, "challenge_token": challenge})
+			return
+		}
+
+		token, refreshToken, err := issueTokens(foundUser)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error generating tokens"})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{"token": token, "refresh_token": refreshToken})
 	}
 }
 
+// issueTokens generates and stores a fresh token pair, it is the last step of every login path
+func issueTokens(user models.User) (string, string, error) {
+	token, refreshToken, err := helper.GenerateAllTokens(*user.Email, *user.FirstName, *user.LastName, user.User_id, userRole(user), user.Restaurant_id)
+	if err != nil {
+		return "", "", err
+	}
+	helper.UpdateAllTokens(token, refreshToken, user.User_id)
+	return token, refreshToken, nil
+}
+
+// UpdateUserRole lets an admin promote or demote another account
+func UpdateUserRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userID := c.Param("id")
+
+		var body struct {
+			Role string `json:"role" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !helper.IsValidRole(body.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "role must be one of ADMIN, MANAGER or STAFF"})
+			return
+		}
+
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		filter := tenantFilter(c, bson.M{"user_id": userID})
+		before := snapshot(ctx, userCollection, filter)
+		result, err := userCollection.UpdateOne(ctx,
+			filter,
+			bson.M{"$set": bson.M{"role": body.Role, "updated_at": updatedAt}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while updating the role"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		recordAudit(ctx, c, "user", userID, AuditUpdate, before, snapshot(ctx, userCollection, filter))
+
+		// the new role is picked up the next time the user logs in
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// accounts created before roles existed have no role stored
+func userRole(user models.User) string {
+	if user.Role == nil {
+		return helper.RoleStaff
+	}
+	return *user.Role
+}
+
 func HashPassword(password string) string {
 	bytes, err := brcypt.GenerateFromPassword([]byte(password), 14)
 	if err != nil {
@@ -0,0 +1,420 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"resturnat-management/helper"
+	"resturnat-management/models"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	errVoidNeedsApproval = errors.New("the kitchen already started on the item, a manager has to approve the void with their pin")
+	errApprovalRefused   = errors.New("the approval was refused, check the manager's email and pin")
+)
+
+// wrong pins in a row before an approval pin is locked until its owner sets it again
+const maxApprovalPinFailures = 5
+
+// itemVoid is why and by whom an order is cancelled or an item voided. Approver is the manager
+// who approved it with their pin, empty when nobody did
+type itemVoid struct {
+	Reason   string
+	Note     *string
+	Actor    string
+	Approver string
+}
+
+// voidApproval is the manager's credential sent along with a void or cancel that needs one
+type voidApproval struct {
+	Email string `json:"email"`
+	Pin   string `json:"pin"`
+}
+
+// newItemVoid checks the reason code sent to cancel or void, OTHER has to say what happened
+func newItemVoid(c *gin.Context, reason string, note *string) (itemVoid, error) {
+	if !models.IsValidVoidReason(reason) {
+		return itemVoid{}, errors.New("reason must be one of " + strings.Join(models.VoidReasons, ", "))
+	}
+	if reason == models.VoidOther && blank(note) {
+		return itemVoid{}, errors.New("a note is required when the reason is OTHER")
+	}
+	return itemVoid{Reason: reason, Note: note, Actor: c.GetString("uid")}, nil
+}
+
+// approveVoid checks a manager's approval and records them as the void's approver. the approver
+// has to be another user of the restaurant whose role may approve voids, so nobody approves
+// their own void. the status code says why an approval was refused
+func approveVoid(ctx context.Context, c *gin.Context, void *itemVoid, approval *voidApproval) (int, error) {
+	if approval == nil {
+		return 0, nil
+	}
+	var approver models.User
+	err := userCollection.FindOne(ctx, tenantFilter(c, bson.M{"email": approval.Email})).Decode(&approver)
+	if err != nil || approver.Approval_pin == nil || approver.User_id == void.Actor ||
+		!helper.RoleHasPermission(userRole(approver), helper.PermVoidApprove) {
+		return http.StatusForbidden, errApprovalRefused
+	}
+	if approver.Approval_pin_failures >= maxApprovalPinFailures {
+		return http.StatusForbidden, errors.New("the manager's pin is locked after too many wrong tries, they have to set it again")
+	}
+
+	filter := bson.M{"user_id": approver.User_id, "restaurant_id": approver.Restaurant_id}
+	if ok, _ := VerifyPassword(approval.Pin, *approver.Approval_pin); !ok {
+		if _, err := userCollection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"approval_pin_failures": 1}}); err != nil {
+			return http.StatusInternalServerError, errors.New("error occured while checking the approval")
+		}
+		return http.StatusForbidden, errApprovalRefused
+	}
+	if approver.Approval_pin_failures > 0 {
+		if _, err := userCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"approval_pin_failures": 0}}); err != nil {
+			return http.StatusInternalServerError, errors.New("error occured while checking the approval")
+		}
+	}
+	void.Approver = approver.User_id
+	return 0, nil
+}
+
+// SetApprovalPin sets the pin the calling manager approves voids with. setting it again also
+// unlocks a pin locked by wrong tries
+func SetApprovalPin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		// an api key has no person behind it to approve anything
+		if c.GetString("auth_type") != helper.AuthTypeUser {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only users can set an approval pin"})
+			return
+		}
+		var body struct {
+			Pin string `json:"pin"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(body.Pin) < 6 || len(body.Pin) > 12 || strings.Trim(body.Pin, "0123456789") != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the pin must be 6 to 12 digits"})
+			return
+		}
+
+		pin := HashPassword(body.Pin)
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		filter := tenantFilter(c, bson.M{"user_id": c.GetString("uid")})
+		result, err := userCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+			"approval_pin":          pin,
+			"approval_pin_failures": 0,
+			"updated_at":            updatedAt,
+		}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "the approval pin was not set"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		recordAudit(ctx, c, "user", c.GetString("uid"), AuditUpdate, nil, gin.H{"approval_pin": "changed"})
+		c.JSON(http.StatusOK, gin.H{"message": "approval pin set"})
+	}
+}
+
+// voidOrderItem voids an item for a reason. an item the kitchen already started on needs an
+// approver on the void, who is recorded. like changeKitchenStatus the update only matches
+// while the item still has the status it was read with, so an item can't start cooking
+// between the approval check and the void
+func voidOrderItem(ctx context.Context, restaurantId, orderItemId string, void itemVoid) (before, after models.OrderItem, err error) {
+	filter := bson.M{"order_item_id": orderItemId, "restaurant_id": restaurantId}
+	if err = orderItemCollection.FindOne(ctx, filter).Decode(&before); err != nil {
+		return before, after, errOrderItemNotFound
+	}
+
+	from := before.CurrentKitchenStatus()
+	if !models.CanTransitionKitchen(from, models.KitchenVoided) {
+		return before, after, fmt.Errorf("%w from %s to %s", errIllegalKitchenTransition, from, models.KitchenVoided)
+	}
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	update := bson.M{
+		"kitchen_status": models.KitchenVoided,
+		"voided_at":      now,
+		"updated_at":     now,
+		"void_reason":    void.Reason,
+		"void_note":      void.Note,
+		"voided_by":      void.Actor,
+	}
+	if before.VoidNeedsApproval() {
+		if void.Approver == "" {
+			return before, after, errVoidNeedsApproval
+		}
+		update["void_approved_by"] = void.Approver
+	}
+
+	err = writeWithEvents(ctx, func(sc mongo.SessionContext) ([]models.OutboxEvent, error) {
+		result, err := orderItemCollection.UpdateOne(sc, kitchenStatusGuard(before), bson.M{"$set": update})
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, errOrderChanged
+		}
+		if err := orderItemCollection.FindOne(sc, filter).Decode(&after); err != nil {
+			return nil, err
+		}
+		return []models.OutboxEvent{orderItemEvent(models.KitchenStatusEvent(models.KitchenVoided), after)}, nil
+	})
+	return before, after, err
+}
+
+// CancelOrder cancels an order for a reason and voids what the kitchen hasn't served yet, all
+// in one transaction. once the kitchen started on any of its items a manager has to approve it
+func CancelOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orderId := c.Param("order_id")
+		var body struct {
+			Reason   string        `json:"reason"`
+			Note     *string       `json:"note"`
+			Approval *voidApproval `json:"approval"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		void, err := newItemVoid(c, body.Reason, body.Note)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if status, err := approveVoid(ctx, c, &void, body.Approval); err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		var order models.Order
+		if err := orderCollection.FindOne(ctx, tenantFilter(c, bson.M{"order_id": orderId})).Decode(&order); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+			return
+		}
+		if !models.CanTransitionOrder(order.CurrentStatus(), models.OrderCancelled) {
+			c.JSON(http.StatusConflict, gin.H{"error": "a " + order.CurrentStatus() + " order can't be cancelled"})
+			return
+		}
+
+		// an item that needs an approval the cancel doesn't have stops the whole cancel
+		var before, after models.Order
+		var itemsBefore, itemsAfter []models.OrderItem
+		err = withTransaction(ctx, func(sc mongo.SessionContext) error {
+			var err error
+			before, after, err = changeOrderStatus(sc, restaurantID(c), orderId, models.OrderCancelled, void.Actor, bson.M{
+				"cancel_reason": void.Reason,
+				"cancel_note":   void.Note,
+				"cancelled_by":  void.Actor,
+			})
+			if err != nil {
+				return err
+			}
+			itemsBefore, itemsAfter, err = voidCancelledItems(sc, after, void)
+			return err
+		})
+		switch {
+		case errors.Is(err, errOrderNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, errVoidNeedsApproval):
+			c.JSON(http.StatusForbidden, gin.H{"error": "the kitchen already started on this order, a manager has to approve the cancel with their pin"})
+			return
+		case errors.Is(err, errIllegalTransition), errors.Is(err, errOrderChanged):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "the order was not cancelled"})
+			return
+		}
+
+		recordAudit(ctx, c, "order", orderId, AuditUpdate, before, after)
+		for i := range itemsAfter {
+			recordAudit(ctx, c, "orderItem", itemsAfter[i].OrderItem_id, AuditUpdate, itemsBefore[i], itemsAfter[i])
+			publishItemEvent(ctx, helper.KitchenItemCancelled, itemsAfter[i])
+		}
+		refreshInvoiceTotals(ctx, after.Restaurant_id, orderId)
+		c.JSON(http.StatusOK, after)
+	}
+}
+
+// VoidOrderItem voids one item of an open order for a reason, it is no longer charged. once
+// the kitchen started on the item a manager has to approve it
+func VoidOrderItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orderItemId := c.Param("id")
+		var body struct {
+			Reason   string        `json:"reason"`
+			Note     *string       `json:"note"`
+			Approval *voidApproval `json:"approval"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		void, err := newItemVoid(c, body.Reason, body.Note)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if status, err := approveVoid(ctx, c, &void, body.Approval); err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		var orderItem models.OrderItem
+		if err := orderItemCollection.FindOne(ctx, tenantFilter(c, bson.M{"order_item_id": orderItemId})).Decode(&orderItem); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": errOrderItemNotFound.Error()})
+			return
+		}
+		if _, status, err := openOrder(ctx, c, orderItem.Order_id); err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		before, after, err := voidOrderItem(ctx, restaurantID(c), orderItemId, void)
+		switch {
+		case errors.Is(err, errOrderItemNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, errVoidNeedsApproval):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, errIllegalKitchenTransition):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, errOrderChanged):
+			c.JSON(http.StatusConflict, gin.H{"error": "the order item was changed by someone else, try again"})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "the order item was not voided"})
+			return
+		}
+
+		recordAudit(ctx, c, "orderItem", orderItemId, AuditUpdate, before, after)
+		publishItemEvent(ctx, helper.KitchenItemCancelled, after)
+		refreshInvoiceTotals(ctx, restaurantID(c), after.Order_id)
+		if orderItemsReady(ctx, restaurantID(c), after.Order_id) {
+			advanceOrder(ctx, c, after.Order_id, models.OrderReady)
+		}
+		c.JSON(http.StatusOK, after)
+	}
+}
+
+// GetVoidReport totals the voided items between from and to by reason and by who voided them.
+// the amount is what the items would have been charged before tax and discounts
+func GetVoidReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		voidedAt, err := parseQueryRange(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		match := tenantFilter(c, bson.M{"kitchen_status": models.KitchenVoided})
+		if voidedAt != nil {
+			match["voided_at"] = voidedAt
+		}
+
+		quantity := bson.M{"$ifNull": bson.A{"$quantity", 1}}
+		amount := bson.M{"$multiply": bson.A{bson.M{"$ifNull": bson.A{"$unit_price", 0}}, quantity}}
+		totals := func(key interface{}) bson.A {
+			return bson.A{
+				bson.M{"$group": bson.M{
+					"_id":    key,
+					"voids":  bson.M{"$sum": 1},
+					"items":  bson.M{"$sum": quantity},
+					"amount": bson.M{"$sum": amount},
+				}},
+				bson.M{"$sort": bson.D{{Key: "amount", Value: -1}, {Key: "_id", Value: 1}}},
+			}
+		}
+		// items voided before reasons existed have none
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: match}},
+			{{Key: "$facet", Value: bson.M{
+				"by_reason": totals(bson.M{"$ifNull": bson.A{"$void_reason", "UNSPECIFIED"}}),
+				"by_staff":  totals(bson.M{"$ifNull": bson.A{"$voided_by", ""}}),
+			}}},
+		}
+
+		type voidTotals struct {
+			Key    string  `bson:"_id"`
+			Voids  int     `bson:"voids"`
+			Items  int     `bson:"items"`
+			Amount float64 `bson:"amount"`
+		}
+		var facets []struct {
+			By_reason []voidTotals `bson:"by_reason"`
+			By_staff  []voidTotals `bson:"by_staff"`
+		}
+		cursor, err := orderItemCollection.Aggregate(ctx, pipeline)
+		if err == nil {
+			err = cursor.All(ctx, &facets)
+		}
+		if err != nil || len(facets) == 0 {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while building the report"})
+			return
+		}
+
+		userIds := bson.A{}
+		for _, group := range facets[0].By_staff {
+			userIds = append(userIds, group.Key)
+		}
+		var users []models.User
+		cursor, err = userCollection.Find(ctx, tenantFilter(c, bson.M{"user_id": bson.M{"$in": userIds}}))
+		if err == nil {
+			err = cursor.All(ctx, &users)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the staff"})
+			return
+		}
+		names := map[string]string{}
+		for _, user := range users {
+			if user.FirstName != nil && user.LastName != nil {
+				names[user.User_id] = *user.FirstName + " " + *user.LastName
+			}
+		}
+
+		byReason := []gin.H{}
+		for _, group := range facets[0].By_reason {
+			byReason = append(byReason, gin.H{
+				"reason": group.Key,
+				"voids":  group.Voids,
+				"items":  group.Items,
+				"amount": toFixed(group.Amount, 2),
+			})
+		}
+		byStaff := []gin.H{}
+		for _, group := range facets[0].By_staff {
+			byStaff = append(byStaff, gin.H{
+				"user_id": group.Key,
+				"name":    names[group.Key],
+				"voids":   group.Voids,
+				"items":   group.Items,
+				"amount":  toFixed(group.Amount, 2),
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{"by_reason": byReason, "by_staff": byStaff})
+	}
+}
@@ -0,0 +1,493 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"resturnat-management/database"
+	"resturnat-management/models"
+	"resturnat-management/webhook"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// how many due deliveries a worker sends per run
+	webhookBatchSize = 100
+	// how many of them are sent at once, so one slow receiver doesn't hold up the rest
+	webhookSenders = 8
+	// a worker holds a delivery this long while it sends it
+	webhookLease = time.Minute
+	// a receiver gets this long to answer
+	webhookTimeout = 10 * time.Second
+	// shorter secrets are too easy to guess
+	minWebhookSecretLength = 16
+)
+
+var webhookCollection *mongo.Collection = database.OpenCollection(database.Client, "webhook")
+var webhookDeliveryCollection *mongo.Collection = database.OpenCollection(database.Client, "webhookDelivery")
+
+var webhookClient = webhook.NewClient(webhookTimeout)
+
+// checkEventTypes makes sure a webhook subscribes to at least one event and only to known ones
+func checkEventTypes(eventTypes []string) error {
+	if len(eventTypes) == 0 {
+		return errors.New("event_types is required")
+	}
+	for _, eventType := range eventTypes {
+		if !slices.Contains(models.EventTypes(), eventType) {
+			return errors.New("unknown event type " + eventType)
+		}
+	}
+	return nil
+}
+
+// webhookSecret is the secret a caller sent, or a new one when they sent none
+func webhookSecret(secret *string) (string, error) {
+	if secret == nil || *secret == "" {
+		return webhook.NewSecret()
+	}
+	if len(*secret) < minWebhookSecretLength {
+		return "", errors.New("secret must be at least 16 characters")
+	}
+	return *secret, nil
+}
+
+// CreateWebhook subscribes a url to event types. without a secret one is made, either way it
+// is only returned here
+func CreateWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Url         string   `json:"url"`
+			Event_types []string `json:"event_types"`
+			Secret      *string  `json:"secret"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		body.Url = strings.TrimSpace(body.Url)
+		if err := webhook.CheckUrl(ctx, body.Url); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := checkEventTypes(body.Event_types); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "event_types": models.EventTypes()})
+			return
+		}
+		secret, err := webhookSecret(body.Secret)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var hook models.Webhook
+		hook.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		hook.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		hook.ID = primitive.NewObjectID()
+		hook.Webhook_id = hook.ID.Hex()
+		hook.Url = body.Url
+		hook.Event_types = body.Event_types
+		hook.Secret = secret
+		hook.Active = true
+		hook.Created_by = c.GetString("uid")
+		hook.Restaurant_id = restaurantID(c)
+
+		if _, err := webhookCollection.InsertOne(ctx, hook); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "webhook was not created"})
+			return
+		}
+		recordAudit(ctx, c, "webhook", hook.Webhook_id, AuditCreate, nil, webhookAudit(hook))
+
+		c.JSON(http.StatusCreated, gin.H{"secret": secret, "data": hook})
+	}
+}
+
+func GetWebhooks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		query, err := parsePageQuery(c, []string{"created_at"}, "-created_at")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// decoded as models so the secrets stay out of the response
+		page, err := findPage[models.Webhook](ctx, webhookCollection, tenantFilter(c, bson.M{}), query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing webhooks"})
+			return
+		}
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// UpdateWebhook changes a webhook's url, event types or secret, or turns it off and on. a new
+// secret is returned once like on create
+func UpdateWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Url         *string  `json:"url"`
+			Event_types []string `json:"event_types"`
+			Active      *bool    `json:"active"`
+			Secret      *string  `json:"secret"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		webhookId := c.Param("webhook_id")
+		filter := tenantFilter(c, bson.M{"webhook_id": webhookId})
+		var before models.Webhook
+		if err := webhookCollection.FindOne(ctx, filter).Decode(&before); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+			return
+		}
+
+		var updateObj primitive.D
+		if body.Url != nil {
+			hookUrl := strings.TrimSpace(*body.Url)
+			if err := webhook.CheckUrl(ctx, hookUrl); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "url", Value: hookUrl})
+		}
+		if body.Event_types != nil {
+			if err := checkEventTypes(body.Event_types); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "event_types": models.EventTypes()})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "event_types", Value: body.Event_types})
+		}
+		if body.Active != nil {
+			updateObj = append(updateObj, bson.E{Key: "active", Value: *body.Active})
+		}
+		var secret *string
+		if body.Secret != nil {
+			newSecret, err := webhookSecret(body.Secret)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			secret = &newSecret
+			updateObj = append(updateObj, bson.E{Key: "secret", Value: newSecret})
+		}
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: updatedAt})
+
+		if _, err := webhookCollection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: updateObj}}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "webhook update failed"})
+			return
+		}
+		var after models.Webhook
+		if err := webhookCollection.FindOne(ctx, filter).Decode(&after); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the webhook"})
+			return
+		}
+		recordAudit(ctx, c, "webhook", webhookId, AuditUpdate, webhookAudit(before), webhookAudit(after))
+
+		response := gin.H{"data": after}
+		if secret != nil {
+			response["secret"] = *secret
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// DeleteWebhook removes a webhook, what was still waiting to be delivered to it ends up with
+// the dead letters
+func DeleteWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		webhookId := c.Param("webhook_id")
+		filter := tenantFilter(c, bson.M{"webhook_id": webhookId})
+		var before models.Webhook
+		if err := webhookCollection.FindOne(ctx, filter).Decode(&before); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+			return
+		}
+		if _, err := webhookCollection.DeleteOne(ctx, filter); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "webhook was not deleted"})
+			return
+		}
+		recordAudit(ctx, c, "webhook", webhookId, AuditDelete, webhookAudit(before), nil)
+		c.JSON(http.StatusOK, gin.H{"message": "webhook deleted successfully"})
+	}
+}
+
+// webhookAudit is a webhook without its secret, which has no place in the audit log
+func webhookAudit(hook models.Webhook) models.Webhook {
+	hook.Secret = ""
+	return hook
+}
+
+// GetWebhookDeliveries is the delivery log, newest first, filtered by webhook_id, event_type
+// and status. status=DEAD lists the dead letters
+func GetWebhookDeliveries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := tenantFilter(c, bson.M{})
+		if webhookId := c.Query("webhook_id"); webhookId != "" {
+			filter["webhook_id"] = webhookId
+		}
+		if eventType := c.Query("event_type"); eventType != "" {
+			filter["event_type"] = eventType
+		}
+		if status := c.Query("status"); status != "" {
+			if status != models.DeliveryPending && status != models.DeliveryDelivered && status != models.DeliveryDead {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "status must be PENDING, DELIVERED or DEAD"})
+				return
+			}
+			filter["status"] = status
+		}
+
+		query, err := parsePageQuery(c, []string{"created_at", "updated_at"}, "-created_at")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		page, err := findPage[models.WebhookDelivery](ctx, webhookDeliveryCollection, filter, query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing webhook deliveries"})
+			return
+		}
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// RetryWebhookDelivery sends a dead letter again, with a fresh set of attempts
+func RetryWebhookDelivery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		deliveryId := c.Param("delivery_id")
+		filter := tenantFilter(c, bson.M{"delivery_id": deliveryId})
+		before := snapshot(ctx, webhookDeliveryCollection, filter)
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		result, err := webhookDeliveryCollection.UpdateOne(ctx,
+			tenantFilter(c, bson.M{"delivery_id": deliveryId, "status": models.DeliveryDead}),
+			bson.M{"$set": bson.M{"status": models.DeliveryPending, "attempts": 0, "next_attempt_at": now, "updated_at": now}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "the delivery was not retried"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "delivery not found or not a dead letter"})
+			return
+		}
+		recordAudit(ctx, c, "webhookDelivery", deliveryId, AuditUpdate, before, snapshot(ctx, webhookDeliveryCollection, filter))
+		c.JSON(http.StatusOK, gin.H{"message": "the delivery will be sent again"})
+	}
+}
+
+// queueWebhookDeliveries queues the event for every active webhook of its restaurant that
+// subscribes to it. the relay calls it for every event it publishes, and can call it twice for
+// the same event, so a delivery is only queued once per webhook and event
+func queueWebhookDeliveries(ctx context.Context, event models.OutboxEvent) error {
+	var hooks []models.Webhook
+	cursor, err := webhookCollection.Find(ctx, bson.M{
+		"restaurant_id": event.Restaurant_id,
+		"active":        true,
+		"event_types":   event.Type,
+	})
+	if err == nil {
+		err = cursor.All(ctx, &hooks)
+	}
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+
+	body, err := json.Marshal(webhook.Event{Id: event.Event_id, Type: event.Type, Created_at: event.Created_at, Data: event.Data})
+	if err != nil {
+		return err
+	}
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	for _, hook := range hooks {
+		id := primitive.NewObjectID()
+		delivery := models.WebhookDelivery{
+			ID:              id,
+			Delivery_id:     id.Hex(),
+			Webhook_id:      hook.Webhook_id,
+			Event_id:        event.Event_id,
+			Event_type:      event.Type,
+			Body:            string(body),
+			Status:          models.DeliveryPending,
+			Next_attempt_at: &now,
+			Attempt_log:     []models.WebhookAttempt{},
+			Created_at:      now,
+			Updated_at:      now,
+			Restaurant_id:   event.Restaurant_id,
+		}
+		_, err := webhookDeliveryCollection.UpdateOne(ctx,
+			bson.M{"webhook_id": hook.Webhook_id, "event_id": event.Event_id},
+			bson.M{"$setOnInsert": delivery},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WatchWebhookDeliveries sends the webhook deliveries that are due until ctx is done, main runs
+// it in the background
+func WatchWebhookDeliveries(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sendDueDeliveries(ctx)
+		}
+	}
+}
+
+// sendDueDeliveries makes an attempt at every pending delivery whose time has come. a failed
+// delivery waits longer after every attempt and becomes a dead letter once it is out of
+// attempts. like the outbox relay, a delivery is leased while it is sent so workers on other
+// api instances leave it alone. up to webhookSenders deliveries are sent at the same time
+func sendDueDeliveries(ctx context.Context) {
+	now := time.Now().UTC()
+	var deliveries []models.WebhookDelivery
+	cursor, err := webhookDeliveryCollection.Find(ctx,
+		bson.M{"status": models.DeliveryPending, "next_attempt_at": bson.M{"$lte": now}},
+		options.Find().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).SetLimit(webhookBatchSize))
+	if err == nil {
+		err = cursor.All(ctx, &deliveries)
+	}
+	if err != nil {
+		log.Printf("webhook deliveries could not be fetched: %v", err)
+		return
+	}
+
+	senders := make(chan struct{}, webhookSenders)
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		senders <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-senders
+				wg.Done()
+			}()
+			now := time.Now().UTC()
+			claim := bson.M{"_id": delivery.ID, "status": models.DeliveryPending, "$or": bson.A{
+				bson.M{"locked_until": nil},
+				bson.M{"locked_until": bson.M{"$lte": now}},
+			}}
+			result, err := webhookDeliveryCollection.UpdateOne(ctx, claim, bson.M{"$set": bson.M{"locked_until": now.Add(webhookLease)}})
+			if err != nil || result.ModifiedCount == 0 {
+				return
+			}
+			sendDelivery(ctx, delivery)
+		}()
+	}
+	wg.Wait()
+}
+
+// sendDelivery makes one attempt at a leased delivery and records how it went
+func sendDelivery(ctx context.Context, delivery models.WebhookDelivery) {
+	var hook models.Webhook
+	err := webhookCollection.FindOne(ctx, bson.M{"webhook_id": delivery.Webhook_id, "restaurant_id": delivery.Restaurant_id}).Decode(&hook)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		deadLetter(ctx, delivery, "the webhook was deleted")
+		return
+	case err != nil:
+		log.Printf("webhook of delivery %s could not be fetched: %v", delivery.Delivery_id, err)
+		return
+	case !hook.Active:
+		deadLetter(ctx, delivery, "the webhook was turned off")
+		return
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	outcome := webhook.Send(sendCtx, webhookClient, webhook.Request{
+		Url:         hook.Url,
+		Secret:      hook.Secret,
+		Event_type:  delivery.Event_type,
+		Delivery_id: delivery.Delivery_id,
+		Body:        []byte(delivery.Body),
+	}, time.Now())
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	attempt := models.WebhookAttempt{
+		Attempted_at: now,
+		Url:          hook.Url,
+		Status_code:  outcome.Status_code,
+		Response:     outcome.Response,
+		Duration_ms:  outcome.Duration.Milliseconds(),
+	}
+	attempts := delivery.Attempts + 1
+	update := bson.M{"attempts": attempts, "updated_at": now, "locked_until": nil}
+	switch {
+	case outcome.Err == nil:
+		update["status"] = models.DeliveryDelivered
+		update["delivered_at"] = now
+		update["next_attempt_at"] = nil
+		update["last_error"] = nil
+	case attempts >= webhook.MaxAttempts:
+		message := outcome.Err.Error()
+		attempt.Error = &message
+		update["status"] = models.DeliveryDead
+		update["next_attempt_at"] = nil
+		update["last_error"] = message
+	default:
+		message := outcome.Err.Error()
+		attempt.Error = &message
+		update["next_attempt_at"] = now.Add(webhook.Backoff(attempts))
+		update["last_error"] = message
+	}
+
+	_, err = webhookDeliveryCollection.UpdateOne(ctx, bson.M{"_id": delivery.ID}, bson.M{
+		"$set":  update,
+		"$push": bson.M{"attempt_log": attempt},
+	})
+	if err != nil {
+		log.Printf("attempt at webhook delivery %s was not recorded: %v", delivery.Delivery_id, err)
+	}
+}
+
+// deadLetter gives up on a delivery that can't be sent at all
+func deadLetter(ctx context.Context, delivery models.WebhookDelivery, reason string) {
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	_, err := webhookDeliveryCollection.UpdateOne(ctx, bson.M{"_id": delivery.ID}, bson.M{"$set": bson.M{
+		"status":          models.DeliveryDead,
+		"next_attempt_at": nil,
+		"last_error":      reason,
+		"locked_until":    nil,
+		"updated_at":      now,
+	}})
+	if err != nil {
+		log.Printf("webhook delivery %s was not moved to the dead letters: %v", delivery.Delivery_id, err)
+	}
+}
@@ -47,7 +47,15 @@ func DBinstance() *mongo.Client {
 // Global client instance
 var Client *mongo.Client = DBinstance()
 
+// DatabaseName is the database all collections live in, MONGODB_DATABASE overrides the default
+func DatabaseName() string {
+	if name := os.Getenv("MONGODB_DATABASE"); name != "" {
+		return name
+	}
+	return "restaurant"
+}
+
 // OpenCollection returns a collection reference
 func OpenCollection(client *mongo.Client, collectionName string) *mongo.Collection {
-	return client.Database("restaurant").Collection(collectionName)
+	return client.Database(DatabaseName()).Collection(collectionName)
 }
@@ -0,0 +1,96 @@
+package helper
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"resturnat-management/database"
+	"resturnat-management/models"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// api keys look like rk_<prefix>_<secret>, only the prefix is stored in clear text
+const apiKeyPrefix = "rk"
+
+// last_used_at is only written once per interval so every request doesn't cost a db write
+const apiKeyLastUsedInterval = time.Minute
+
+var apiKeyCollection *mongo.Collection = database.OpenCollection(database.Client, "apiKey")
+
+// GenerateApiKey returns the plain key to hand to the caller once, along with the
+// prefix used to look it up and the hash that is stored
+func GenerateApiKey() (plainKey, prefix, hash string, err error) {
+	prefixBytes := make([]byte, 4)
+	if _, err = rand.Read(prefixBytes); err != nil {
+		return "", "", "", err
+	}
+	secretBytes := make([]byte, 24)
+	if _, err = rand.Read(secretBytes); err != nil {
+		return "", "", "", err
+	}
+
+	prefix = hex.EncodeToString(prefixBytes)
+	plainKey = apiKeyPrefix + "_" + prefix + "_" + hex.EncodeToString(secretBytes)
+	return plainKey, prefix, HashApiKey(plainKey), nil
+}
+
+// api keys are long random strings so a plain sha256 is enough, unlike passwords
+func HashApiKey(plainKey string) string {
+	sum := sha256.Sum256([]byte(plainKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func parseApiKeyPrefix(plainKey string) (string, bool) {
+	parts := strings.Split(plainKey, "_")
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+func ValidateApiKey(plainKey string) (apiKey *models.ApiKey, msg string) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	prefix, ok := parseApiKeyPrefix(plainKey)
+	if !ok {
+		msg = "malformed api key"
+		return
+	}
+
+	var found models.ApiKey
+	if err := apiKeyCollection.FindOne(ctx, bson.M{"prefix": prefix}).Decode(&found); err != nil {
+		msg = "api key not found"
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(found.Key_hash), []byte(HashApiKey(plainKey))) != 1 {
+		msg = "api key not found"
+		return
+	}
+
+	if found.Revoked_at != nil {
+		msg = "api key has been revoked"
+		return
+	}
+
+	now := time.Now()
+	if found.Last_used_at == nil || now.Sub(*found.Last_used_at) > apiKeyLastUsedInterval {
+		_, err := apiKeyCollection.UpdateOne(ctx,
+			bson.M{"api_key_id": found.Api_key_id},
+			bson.M{"$set": bson.M{"last_used_at": now}},
+		)
+		if err != nil {
+			log.Printf("Failed to update last_used_at for api key %s: %v", found.Api_key_id, err)
+		}
+	}
+
+	return &found, msg
+}
@@ -0,0 +1,64 @@
+package helper
+
+import (
+	"reflect"
+	"resturnat-management/models"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// fields that change on every write or only identify the document, they add noise to a diff
+var auditIgnoredFields = map[string]bool{
+	"_id":        true,
+	"updated_at": true,
+}
+
+// secrets are never copied into the audit log, only the fact that they changed
+var auditRedactedFields = map[string]bool{
+	"password":       true,
+	"token":          true,
+	"refresh_token":  true,
+	"key_hash":       true,
+	"totp_secret":    true,
+	"recovery_codes": true,
+}
+
+const auditRedacted = "[redacted]"
+
+// DiffDocuments lists the fields that differ between two versions of a document.
+// before is nil for a create and after is nil for a delete
+func DiffDocuments(before, after bson.M) []models.FieldChange {
+	fields := map[string]bool{}
+	for field := range before {
+		fields[field] = true
+	}
+	for field := range after {
+		fields[field] = true
+	}
+
+	changes := []models.FieldChange{}
+	for field := range fields {
+		if auditIgnoredFields[field] {
+			continue
+		}
+		oldValue, hadOld := before[field]
+		newValue, hasNew := after[field]
+		if hadOld == hasNew && reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+
+		if auditRedactedFields[field] {
+			if hadOld {
+				oldValue = auditRedacted
+			}
+			if hasNew {
+				newValue = auditRedacted
+			}
+		}
+		changes = append(changes, models.FieldChange{Field: field, Before: oldValue, After: newValue})
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
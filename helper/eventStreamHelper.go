@@ -0,0 +1,133 @@
+package helper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"resturnat-management/config"
+	"resturnat-management/models"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// a restaurant's event stream keeps roughly this many events for consumers that replay
+const eventStreamLength = 100000
+
+var (
+	ErrEventGroupExists   = errors.New("the consumer group already exists")
+	ErrEventGroupNotFound = errors.New("no such consumer group")
+)
+
+// OrderEvent is an outbox event the way consumers read it off a restaurant's event stream. Id is
+// the stream id, consumers keep it as the offset to replay from
+type OrderEvent struct {
+	Id            string                 `json:"id"`
+	Event_id      string                 `json:"event_id"`
+	Type          string                 `json:"type"`
+	Entity        string                 `json:"entity"`
+	Entity_id     string                 `json:"entity_id"`
+	Restaurant_id string                 `json:"restaurant_id"`
+	Data          map[string]interface{} `json:"data"`
+	Created_at    time.Time              `json:"created_at"`
+}
+
+// every restaurant has its own stream, consumer groups are made per stream
+func eventStreamKey(restaurantId string) string {
+	return "events:" + restaurantId
+}
+
+// PublishOutboxEvent appends an outbox event to its restaurant's stream and returns its stream
+// id. type and event_id are also fields of their own so consumers can filter without decoding
+func PublishOutboxEvent(ctx context.Context, event models.OutboxEvent) (string, error) {
+	data, err := json.Marshal(OrderEvent{
+		Event_id:      event.Event_id,
+		Type:          event.Type,
+		Entity:        event.Entity,
+		Entity_id:     event.Entity_id,
+		Restaurant_id: event.Restaurant_id,
+		Data:          event.Data,
+		Created_at:    event.Created_at,
+	})
+	if err != nil {
+		return "", err
+	}
+	return config.RDB.XAdd(ctx, &redis.XAddArgs{
+		Stream: eventStreamKey(event.Restaurant_id),
+		MaxLen: eventStreamLength,
+		Approx: true,
+		Values: map[string]interface{}{"event_id": event.Event_id, "type": event.Type, "event": data},
+	}).Result()
+}
+
+// OrderEventsAfter returns up to count events that came after the stream id after, from the
+// oldest one kept when after is empty. complete is false when events after it were already
+// trimmed, the consumer then missed some
+func OrderEventsAfter(ctx context.Context, restaurantId, after string, count int64) (events []OrderEvent, complete bool, err error) {
+	key := eventStreamKey(restaurantId)
+
+	oldest, err := config.RDB.XRangeN(ctx, key, "-", "+", 1).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if len(oldest) == 0 {
+		return []OrderEvent{}, true, nil
+	}
+	start := "-"
+	complete = true
+	if after != "" {
+		start = "(" + after
+		complete = CompareStreamIds(oldest[0].ID, after) <= 0
+	}
+
+	messages, err := config.RDB.XRangeN(ctx, key, start, "+", count).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	events = []OrderEvent{}
+	for _, message := range messages {
+		payload, _ := message.Values["event"].(string)
+		var event OrderEvent
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			continue
+		}
+		event.Id = message.ID
+		events = append(events, event)
+	}
+	return events, complete, nil
+}
+
+// CreateEventGroup adds a consumer group to the restaurant's stream. it delivers what comes
+// after the stream id from, 0 for every event still kept and $ for new events only
+func CreateEventGroup(ctx context.Context, restaurantId, group, from string) error {
+	err := config.RDB.XGroupCreateMkStream(ctx, eventStreamKey(restaurantId), group, from).Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return ErrEventGroupExists
+	}
+	return err
+}
+
+// SetEventGroupOffset makes a consumer group deliver again everything after the stream id from.
+// messages the group already delivered but nobody acknowledged stay pending
+func SetEventGroupOffset(ctx context.Context, restaurantId, group, from string) error {
+	err := config.RDB.XGroupSetID(ctx, eventStreamKey(restaurantId), group, from).Err()
+	if err != nil && (strings.HasPrefix(err.Error(), "NOGROUP") || strings.Contains(err.Error(), "no such key")) {
+		return ErrEventGroupNotFound
+	}
+	return err
+}
+
+// EventGroups lists the consumer groups of the restaurant's stream with how far behind they are
+func EventGroups(ctx context.Context, restaurantId string) ([]redis.XInfoGroup, error) {
+	groups, err := config.RDB.XInfoGroups(ctx, eventStreamKey(restaurantId)).Result()
+	if err != nil && strings.Contains(err.Error(), "no such key") {
+		return []redis.XInfoGroup{}, nil
+	}
+	return groups, err
+}
+
+// IsStreamOffset reports whether from can start a consumer group: a stream id, 0 or $
+func IsStreamOffset(from string) bool {
+	return from == "0" || from == "$" || IsStreamId(from)
+}
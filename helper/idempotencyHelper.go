@@ -0,0 +1,65 @@
+package helper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"resturnat-management/config"
+	"resturnat-management/idempotency"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// a finished request's response is kept this long for retries. a request still running holds
+// its key for a shorter while, so one that died halfway doesn't block its retries for a day
+const (
+	IdempotencyTTL        = 24 * time.Hour
+	idempotencyPendingTTL = 2 * time.Minute
+)
+
+// IdempotencyStore keeps idempotency keys in redis, see idempotency.Store
+type IdempotencyStore struct{}
+
+func idempotencyKey(callerKey, key string) string {
+	return "idempotency:" + callerKey + ":" + key
+}
+
+// Claim reserves a caller's key for a request. when the key is already taken,
+// claimed is false and the request that took it is returned instead
+func (IdempotencyStore) Claim(ctx context.Context, callerKey, key, requestHash string) (claimed bool, existing idempotency.Request, err error) {
+	pending, err := json.Marshal(idempotency.Request{Request_hash: requestHash})
+	if err != nil {
+		return false, existing, err
+	}
+	claimed, err = config.RDB.SetNX(ctx, idempotencyKey(callerKey, key), pending, idempotencyPendingTTL).Result()
+	if err != nil || claimed {
+		return claimed, existing, err
+	}
+
+	data, err := config.RDB.Get(ctx, idempotencyKey(callerKey, key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		// it expired in between, the caller can simply retry
+		return false, existing, errors.New("the idempotency key was just released, try again")
+	}
+	if err != nil {
+		return false, existing, err
+	}
+	err = json.Unmarshal(data, &existing)
+	return false, existing, err
+}
+
+// Save keeps the response of a claimed key for replaying to retries
+func (IdempotencyStore) Save(ctx context.Context, callerKey, key string, request idempotency.Request) error {
+	request.Done = true
+	data, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	return config.RDB.Set(ctx, idempotencyKey(callerKey, key), data, IdempotencyTTL).Err()
+}
+
+// Release frees a claimed key without a response, so a retry runs the request again
+func (IdempotencyStore) Release(ctx context.Context, callerKey, key string) error {
+	return config.RDB.Del(ctx, idempotencyKey(callerKey, key)).Err()
+}
@@ -0,0 +1,161 @@
+package helper
+
+import (
+	"context"
+	"encoding/json"
+	"resturnat-management/config"
+	"resturnat-management/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// kinds of kitchen event
+const (
+	KitchenItemCreated   = "order_item.created"
+	KitchenItemUpdated   = "order_item.updated"
+	KitchenItemCancelled = "order_item.cancelled"
+	// an item ran over its prep target
+	KitchenItemLate = "order_item.late"
+)
+
+// every restaurant keeps roughly this many recent events around for clients that reconnect
+const kitchenFeedLength = 1000
+
+// KitchenEvent is pushed to the kitchen displays of a restaurant. the Id is the event's
+// redis stream id, displays send it back as Last-Event-ID to replay what they missed
+type KitchenEvent struct {
+	Id            string           `json:"id"`
+	Type          string           `json:"type"`
+	Restaurant_id string           `json:"restaurant_id"`
+	Order_id      string           `json:"order_id"`
+	Order_item_id string           `json:"order_item_id"`
+	Station       string           `json:"station"`
+	Item          models.OrderItem `json:"item"`
+	Created_at    time.Time        `json:"created_at"`
+}
+
+// each restaurant has a stream that keeps recent events, displays read new events off it and
+// replay the ones they missed from it
+func kitchenFeedKey(restaurantId string) string {
+	return "kitchen:events:" + restaurantId
+}
+
+// PublishKitchenEvent appends the event to the restaurant's stream
+func PublishKitchenEvent(ctx context.Context, event KitchenEvent) (KitchenEvent, error) {
+	event.Created_at = time.Now().UTC()
+	data, err := json.Marshal(event)
+	if err != nil {
+		return event, err
+	}
+
+	id, err := config.RDB.XAdd(ctx, &redis.XAddArgs{
+		Stream: kitchenFeedKey(event.Restaurant_id),
+		MaxLen: kitchenFeedLength,
+		Approx: true,
+		Values: map[string]interface{}{"event": data},
+	}).Result()
+	event.Id = id
+	return event, err
+}
+
+// LatestKitchenEventId is the id of the restaurant's newest event, a display that starts
+// without a Last-Event-ID reads on from there. it is 0-0 while the stream is empty
+func LatestKitchenEventId(ctx context.Context, restaurantId string) (string, error) {
+	messages, err := config.RDB.XRevRangeN(ctx, kitchenFeedKey(restaurantId), "+", "-", 1).Result()
+	if err != nil || len(messages) == 0 {
+		return "0-0", err
+	}
+	return messages[0].ID, nil
+}
+
+// ReadKitchenEvents waits up to block for events after the stream id after and returns them in
+// stream order. the stream orders them by when redis took them, so events from every api
+// instance come out in one order and none is skipped. nothing is returned when block runs out
+func ReadKitchenEvents(ctx context.Context, restaurantId, after string, block time.Duration) ([]KitchenEvent, error) {
+	streams, err := config.RDB.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{kitchenFeedKey(restaurantId), after},
+		Count:   100,
+		Block:   block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil || len(streams) == 0 {
+		return nil, err
+	}
+	return decodeKitchenEvents(streams[0].Messages), nil
+}
+
+func decodeKitchenEvents(messages []redis.XMessage) []KitchenEvent {
+	events := []KitchenEvent{}
+	for _, message := range messages {
+		payload, _ := message.Values["event"].(string)
+		var event KitchenEvent
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			continue
+		}
+		event.Id = message.ID
+		events = append(events, event)
+	}
+	return events
+}
+
+// KitchenEventsSince returns the events after lastId that are still kept. complete is false
+// when older events were already trimmed, the display then has to reload its state
+func KitchenEventsSince(ctx context.Context, restaurantId, lastId string) (events []KitchenEvent, complete bool, err error) {
+	key := kitchenFeedKey(restaurantId)
+
+	oldest, err := config.RDB.XRangeN(ctx, key, "-", "+", 1).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if len(oldest) == 0 {
+		return nil, true, nil
+	}
+	complete = CompareStreamIds(oldest[0].ID, lastId) <= 0
+
+	messages, err := config.RDB.XRange(ctx, key, "("+lastId, "+").Result()
+	if err != nil {
+		return nil, false, err
+	}
+	return decodeKitchenEvents(messages), complete, nil
+}
+
+// CompareStreamIds orders two redis stream ids (<ms>-<seq>) like strings.Compare
+func CompareStreamIds(a, b string) int {
+	aMs, aSeq := splitStreamId(a)
+	bMs, bSeq := splitStreamId(b)
+	switch {
+	case aMs < bMs:
+		return -1
+	case aMs > bMs:
+		return 1
+	case aSeq < bSeq:
+		return -1
+	case aSeq > bSeq:
+		return 1
+	}
+	return 0
+}
+
+func splitStreamId(id string) (uint64, uint64) {
+	ms, seq, _ := strings.Cut(id, "-")
+	msValue, _ := strconv.ParseUint(ms, 10, 64)
+	seqValue, _ := strconv.ParseUint(seq, 10, 64)
+	return msValue, seqValue
+}
+
+// IsStreamId reports whether id looks like a redis stream id, anything else a client sends
+// as Last-Event-ID is ignored
+func IsStreamId(id string) bool {
+	ms, seq, found := strings.Cut(id, "-")
+	if !found {
+		return false
+	}
+	_, msErr := strconv.ParseUint(ms, 10, 64)
+	_, seqErr := strconv.ParseUint(seq, 10, 64)
+	return msErr == nil && seqErr == nil
+}
@@ -0,0 +1,103 @@
+package helper
+
+import "slices"
+
+// roles a user account can hold
+const (
+	RoleAdmin   = "ADMIN"
+	RoleManager = "MANAGER"
+	RoleStaff   = "STAFF"
+)
+
+// how the caller of a request was authenticated
+const (
+	AuthTypeUser   = "user"
+	AuthTypeApiKey = "api_key"
+	// changes the api makes by itself, only ever seen in the audit log
+	AuthTypeSystem = "system"
+)
+
+// permissions checked by the Authorize middleware, they double as api key scopes
+const (
+	PermMenuRead    = "menu:read"
+	PermMenuWrite   = "menu:write"
+	PermTablesRead  = "tables:read"
+	PermTablesWrite = "tables:write"
+	PermOrdersRead  = "orders:read"
+	PermOrdersWrite = "orders:write"
+	// lets a caller charge a different unit price than the menu's
+	PermPriceOverride = "orders:price_override"
+	// held by the manager who approves a void of an item the kitchen already started on, or a
+	// cancel. the approval is another manager's email and approval pin, sent with the request
+	PermVoidApprove    = "orders:void_approve"
+	PermKitchenUpdate  = "kitchen:update"
+	PermInvoicesRead   = "invoices:read"
+	PermInvoicesWrite  = "invoices:write"
+	PermNotesRead      = "notes:read"
+	PermNotesWrite     = "notes:write"
+	PermAuditRead      = "audit:read"
+	PermReportsRead    = "reports:read"
+	PermApiKeysManage  = "apikeys:manage"
+	PermUsersManage    = "users:manage"
+	PermSettingsManage = "settings:manage"
+	// reading the order event stream, and moving consumer groups around on it
+	PermEventsRead   = "events:read"
+	PermEventsManage = "events:manage"
+	// webhooks send order data to any url, so like api keys only admins manage them
+	PermWebhooksManage = "webhooks:manage"
+)
+
+// ApiKeyScopes are the permissions an admin may grant to an api key.
+// managing keys and users is deliberately left out so a leaked key can't escalate
+var ApiKeyScopes = []string{
+	PermMenuRead, PermMenuWrite,
+	PermTablesRead, PermTablesWrite,
+	PermOrdersRead, PermOrdersWrite, PermPriceOverride, PermVoidApprove,
+	PermKitchenUpdate,
+	PermInvoicesRead, PermInvoicesWrite,
+	PermNotesRead, PermNotesWrite,
+	PermAuditRead, PermReportsRead,
+	PermEventsRead, PermEventsManage,
+}
+
+var staffPermissions = []string{
+	PermMenuRead,
+	PermTablesRead, PermTablesWrite,
+	PermOrdersRead, PermOrdersWrite,
+	PermKitchenUpdate,
+	PermInvoicesRead, PermInvoicesWrite,
+	PermNotesRead, PermNotesWrite,
+}
+
+var rolePermissions = map[string][]string{
+	RoleAdmin:   append(slices.Clone(ApiKeyScopes), PermApiKeysManage, PermUsersManage, PermSettingsManage, PermWebhooksManage),
+	RoleManager: ApiKeyScopes,
+	RoleStaff:   staffPermissions,
+}
+
+// RoleHasPermission reports whether a user with the given role may use the permission.
+// accounts created before roles existed have no role and are treated as staff
+func RoleHasPermission(role, permission string) bool {
+	if role == "" {
+		role = RoleStaff
+	}
+	return slices.Contains(rolePermissions[role], permission)
+}
+
+// HasPermission reports whether a caller may use the permission, users are checked
+// by their role and api keys by their scopes
+func HasPermission(authType, role string, scopes []string, permission string) bool {
+	if authType == AuthTypeApiKey {
+		return slices.Contains(scopes, permission)
+	}
+	return RoleHasPermission(role, permission)
+}
+
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func IsValidApiKeyScope(scope string) bool {
+	return slices.Contains(ApiKeyScopes, scope)
+}
@@ -14,22 +14,34 @@ import (
 )
 
 type SignedDetails struct {
-	Email      string
-	First_Name string
-	Last_Name  string
-	Uid        string
+	Email         string
+	First_Name    string
+	Last_Name     string
+	Uid           string
+	Role          string
+	Restaurant_id string
+	// set only on short-lived challenge tokens, which can't be used to call the api
+	Purpose string
 	jwt.RegisteredClaims
 }
 
+// purposes of challenge tokens handed out during a two-step login
+const (
+	ChallengeTwoFactor  = "2fa_login"
+	ChallengeEnrollment = "2fa_enroll"
+)
+
 var userCollection *mongo.Collection = database.OpenCollection(database.Client, "user")
 var SecretKey = os.Getenv("SECRET_KEY")
 
-func GenerateAllTokens(email, firstName, lastName, uid string) (string, string, error) {
+func GenerateAllTokens(email, firstName, lastName, uid, role, restaurantID string) (string, string, error) {
 	claims := &SignedDetails{
-		First_Name: firstName,
-		Last_Name:  lastName,
-		Email:      email,
-		Uid:        uid,
+		First_Name:    firstName,
+		Last_Name:     lastName,
+		Email:         email,
+		Uid:           uid,
+		Role:          role,
+		Restaurant_id: restaurantID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Local().Add(24 * time.Hour)),
 		},
@@ -54,6 +66,20 @@ func GenerateAllTokens(email, firstName, lastName, uid string) (string, string,
 	return token, refreshToken, nil
 }
 
+// GenerateChallengeToken issues a token that only proves the password step of a login
+// succeeded, it is exchanged for real tokens once the second factor is checked
+func GenerateChallengeToken(uid, restaurantID, purpose string) (string, error) {
+	claims := &SignedDetails{
+		Uid:           uid,
+		Restaurant_id: restaurantID,
+		Purpose:       purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Local().Add(5 * time.Minute)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SecretKey))
+}
+
 func UpdateAllTokens(signedToken string, signedRefreshToken string, userId string) {
 	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 	defer cancel()
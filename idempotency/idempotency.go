@@ -0,0 +1,148 @@
+// Package idempotency makes create requests safe to retry with an Idempotency-Key header. it
+// only knows about gin and a Store, the redis store lives in helper
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	Header = "Idempotency-Key"
+	// set on a response that was replayed from the first request's
+	ReplayedHeader = "Idempotent-Replayed"
+	MaxKeyLength   = 255
+)
+
+// Request is what is kept under an idempotency key. Done is false while the first request is
+// still running
+type Request struct {
+	Request_hash string `json:"request_hash"`
+	Done         bool   `json:"done"`
+	Status       int    `json:"status"`
+	Content_type string `json:"content_type"`
+	Body         string `json:"body"`
+}
+
+// Store keeps idempotency keys per caller
+type Store interface {
+	// Claim reserves a caller's key for a request. when the key is already taken, claimed is
+	// false and the request that took it is returned instead
+	Claim(ctx context.Context, callerKey, key, requestHash string) (claimed bool, existing Request, err error)
+	// Save keeps the response of a claimed key for replaying to retries
+	Save(ctx context.Context, callerKey, key string, request Request) error
+	// Release frees a claimed key without a response, so a retry runs the request again
+	Release(ctx context.Context, callerKey, key string) error
+}
+
+// responseRecorder copies everything a handler writes so it can be kept for retries
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(data string) (int, error) {
+	r.body.WriteString(data)
+	return r.ResponseWriter.WriteString(data)
+}
+
+// Handler runs a request sent with an Idempotency-Key once per caller and key. retries get the
+// first response replayed and reusing a key for a different request is rejected. server errors
+// aren't kept so they can be retried. requests without the header run as usual, and so does
+// everything when the store fails. every store call gets its own timeout, so a slow handler
+// doesn't use up the time to keep its response. the caller is read from the uid, auth_type and
+// restaurant_id that Authentication sets
+func Handler(store Store, timeout time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader(Header)
+		if key == "" {
+			ctx.Next()
+			return
+		}
+		if len(key) > MaxKeyLength {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": "Idempotency-Key can't be longer than 255 characters",
+			})
+			ctx.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(ctx.Request.Body)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": "Could not read the request body",
+			})
+			ctx.Abort()
+			return
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(ctx.Request.Method + " " + ctx.Request.URL.Path + "\n"))
+		hash.Write(body)
+		requestHash := hex.EncodeToString(hash.Sum(nil))
+
+		// keys are per caller, two tablets picking the same key don't collide
+		callerKey := ctx.GetString("restaurant_id") + ":" + ctx.GetString("auth_type") + ":" + ctx.GetString("uid")
+
+		claimCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		claimed, existing, err := store.Claim(claimCtx, callerKey, key, requestHash)
+		cancel()
+		if err != nil {
+			log.Printf("idempotency key could not be checked, running the request anyway: %v", err)
+			ctx.Next()
+			return
+		}
+		if !claimed {
+			switch {
+			case existing.Request_hash != requestHash:
+				ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+					"error": "Idempotency-Key was already used for a different request",
+				})
+			case !existing.Done:
+				ctx.JSON(http.StatusConflict, gin.H{
+					"error": "A request with this Idempotency-Key is still running, retry later",
+				})
+			default:
+				ctx.Header(ReplayedHeader, "true")
+				ctx.Data(existing.Status, existing.Content_type, []byte(existing.Body))
+			}
+			ctx.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: ctx.Writer}
+		ctx.Writer = recorder
+		ctx.Next()
+
+		saveCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if recorder.Status() >= http.StatusInternalServerError {
+			err = store.Release(saveCtx, callerKey, key)
+		} else {
+			err = store.Save(saveCtx, callerKey, key, Request{
+				Request_hash: requestHash,
+				Done:         true,
+				Status:       recorder.Status(),
+				Content_type: recorder.Header().Get("Content-Type"),
+				Body:         recorder.body.String(),
+			})
+		}
+		if err != nil {
+			log.Printf("response for idempotency key was not kept: %v", err)
+		}
+	}
+}
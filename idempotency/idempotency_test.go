@@ -0,0 +1,208 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// memoryStore is a Store in a map. like redis it refuses calls whose context is done
+type memoryStore struct {
+	mu       sync.Mutex
+	requests map[string]Request
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{requests: map[string]Request{}}
+}
+
+func (s *memoryStore) Claim(ctx context.Context, callerKey, key, requestHash string) (bool, Request, error) {
+	if err := ctx.Err(); err != nil {
+		return false, Request{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.requests[callerKey+":"+key]; ok {
+		return false, existing, nil
+	}
+	s.requests[callerKey+":"+key] = Request{Request_hash: requestHash}
+	return true, Request{}, nil
+}
+
+func (s *memoryStore) Save(ctx context.Context, callerKey, key string, request Request) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[callerKey+":"+key] = request
+	return nil
+}
+
+func (s *memoryStore) Release(ctx context.Context, callerKey, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.requests, callerKey+":"+key)
+	return nil
+}
+
+// newRouter serves handler on POST /order behind Handler, as an authenticated user
+func newRouter(store Store, timeout time.Duration, handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("restaurant_id", "r1")
+		c.Set("auth_type", "user")
+		c.Set("uid", "u1")
+	})
+	router.POST("/order", Handler(store, timeout), handler)
+	return router
+}
+
+func post(router *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/order", strings.NewReader(body))
+	if key != "" {
+		request.Header.Set(Header, key)
+	}
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	return response
+}
+
+// countingHandler creates an order per call, the response says which call it was
+func countingHandler(calls *int32) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		call := atomic.AddInt32(calls, 1)
+		c.JSON(http.StatusCreated, gin.H{"call": call})
+	}
+}
+
+func TestReplaysTheFirstResponse(t *testing.T) {
+	var calls int32
+	router := newRouter(newMemoryStore(), time.Second, countingHandler(&calls))
+
+	first := post(router, "k1", `{"table_id":"t1"}`)
+	retry := post(router, "k1", `{"table_id":"t1"}`)
+
+	if calls != 1 {
+		t.Fatalf("the handler ran %d times, want 1", calls)
+	}
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry got %d %s, want the first response %d %s", retry.Code, retry.Body, first.Code, first.Body)
+	}
+	if retry.Header().Get(ReplayedHeader) != "true" {
+		t.Errorf("the replayed response has no %s header", ReplayedHeader)
+	}
+	if first.Header().Get(ReplayedHeader) != "" {
+		t.Errorf("the first response is marked as replayed")
+	}
+}
+
+func TestRunsRequestsWithoutAKey(t *testing.T) {
+	var calls int32
+	router := newRouter(newMemoryStore(), time.Second, countingHandler(&calls))
+
+	post(router, "", `{}`)
+	post(router, "", `{}`)
+	if calls != 2 {
+		t.Errorf("the handler ran %d times, want 2", calls)
+	}
+}
+
+func TestRejectsAKeyReusedForAnotherBody(t *testing.T) {
+	var calls int32
+	router := newRouter(newMemoryStore(), time.Second, countingHandler(&calls))
+
+	post(router, "k1", `{"table_id":"t1"}`)
+	other := post(router, "k1", `{"table_id":"t2"}`)
+
+	if other.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want 422", other.Code)
+	}
+	if calls != 1 {
+		t.Errorf("the handler ran %d times, want 1", calls)
+	}
+}
+
+func TestRejectsARetryWhileTheFirstIsRunning(t *testing.T) {
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	var calls int32
+	router := newRouter(newMemoryStore(), time.Second, func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		close(started)
+		<-finish
+		c.JSON(http.StatusCreated, gin.H{})
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post(router, "k1", `{}`) }()
+	<-started
+
+	retry := post(router, "k1", `{}`)
+	close(finish)
+	first := <-done
+
+	if retry.Code != http.StatusConflict {
+		t.Errorf("retry status = %d, want 409", retry.Code)
+	}
+	if first.Code != http.StatusCreated {
+		t.Errorf("first status = %d, want 201", first.Code)
+	}
+	if calls != 1 {
+		t.Errorf("the handler ran %d times, want 1", calls)
+	}
+}
+
+func TestReleasesTheKeyOnServerError(t *testing.T) {
+	var calls int32
+	router := newRouter(newMemoryStore(), time.Second, func(c *gin.Context) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "the order was not created"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{})
+	})
+
+	failed := post(router, "k1", `{}`)
+	retry := post(router, "k1", `{}`)
+
+	if failed.Code != http.StatusInternalServerError {
+		t.Fatalf("first status = %d, want 500", failed.Code)
+	}
+	if retry.Code != http.StatusCreated || retry.Header().Get(ReplayedHeader) != "" {
+		t.Errorf("retry got %d replayed=%q, want a fresh 201", retry.Code, retry.Header().Get(ReplayedHeader))
+	}
+	if calls != 2 {
+		t.Errorf("the handler ran %d times, want 2", calls)
+	}
+}
+
+func TestKeepsTheResponseOfASlowHandler(t *testing.T) {
+	var calls int32
+	router := newRouter(newMemoryStore(), 20*time.Millisecond, func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		c.JSON(http.StatusCreated, gin.H{})
+	})
+
+	post(router, "k1", `{}`)
+	retry := post(router, "k1", `{}`)
+
+	if calls != 1 {
+		t.Errorf("the handler ran %d times, want 1", calls)
+	}
+	if retry.Header().Get(ReplayedHeader) != "true" {
+		t.Errorf("the retry wasn't replayed, the slow handler's response was not kept")
+	}
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"resturnat-management/config"
+	"resturnat-management/controller"
+	"resturnat-management/migrations"
 	"resturnat-management/routes"
 
 	middleware "resturnat-management/middleware"
@@ -22,6 +26,10 @@ func main() {
 		port = "8080"
 	}
 
+	if err := migrations.Run(); err != nil {
+		log.Fatal(err)
+	}
+
 	router := gin.New()
 	router.Use(gin.Logger())
 
@@ -45,6 +53,22 @@ func main() {
 	routes.TableRouter(router)
 	routes.InvoiceRouter(router)
 	routes.NoteRouter(router)
+	routes.ApiKeyRouter(router)
+	routes.AuditRouter(router)
+	routes.SettingRouter(router)
+	routes.KitchenRouter(router)
+	routes.ReportRouter(router)
+	routes.EventRouter(router)
+	routes.WebhookRouter(router)
+
+	// late order items are flagged on the kitchen feed, so this needs redis
+	go controller.WatchPrepTimes(context.Background(), time.Minute)
+	// pre-orders are placed in the kitchen at their restaurant's lead time
+	go controller.WatchScheduledOrders(context.Background(), time.Minute)
+	// order, order item and invoice events go from the outbox to the restaurants' event streams
+	go controller.RelayOutbox(context.Background(), time.Second)
+	// and from there to the webhooks that subscribe to them
+	go controller.WatchWebhookDeliveries(context.Background(), 5*time.Second)
 
 	router.Run(":" + port)
 }
@@ -9,6 +9,26 @@ import (
 
 func Authentication() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		// machines such as kitchen screens and printers send an api key instead of a token
+		if apiKey := ctx.Request.Header.Get("X-API-Key"); apiKey != "" {
+			key, err := helper.ValidateApiKey(apiKey)
+			if err != "" {
+				ctx.JSON(http.StatusUnauthorized, gin.H{
+					"error": "Invalid or revoked api key",
+				})
+				ctx.Abort()
+				return
+			}
+
+			ctx.Set("auth_type", helper.AuthTypeApiKey)
+			ctx.Set("uid", key.Api_key_id)
+			ctx.Set("scopes", key.Scopes)
+			ctx.Set("restaurant_id", key.Restaurant_id)
+
+			ctx.Next()
+			return
+		}
+
 		clientToken := ctx.Request.Header.Get("token")
 		if clientToken == "" {
 			ctx.JSON(http.StatusUnauthorized, gin.H{
@@ -27,10 +47,72 @@ func Authentication() gin.HandlerFunc {
 			return
 		}
 
+		// challenge tokens from a two-step login only work on the two-factor routes
+		if claims.Purpose != "" {
+			ctx.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Login is not complete, finish the two-factor step first",
+			})
+			ctx.Abort()
+			return
+		}
+
+		// tokens issued before multi-branch support can't be scoped to a restaurant
+		if claims.Restaurant_id == "" {
+			ctx.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Token has no restaurant, please log in again",
+			})
+			ctx.Abort()
+			return
+		}
+
+		ctx.Set("auth_type", helper.AuthTypeUser)
 		ctx.Set("email", claims.Email)
 		ctx.Set("first_name", claims.First_Name)
 		ctx.Set("last_name", claims.Last_Name)
 		ctx.Set("uid", claims.Uid)
+		ctx.Set("role", claims.Role)
+		ctx.Set("restaurant_id", claims.Restaurant_id)
+
+		ctx.Next()
+	}
+}
+
+// EnrollmentAuthentication guards the two-factor enrollment routes. besides a normal token it
+// accepts the challenge token Login returns when a role must enrol before it can log in
+func EnrollmentAuthentication() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		claims, err := helper.ValidateToken(ctx.Request.Header.Get("token"))
+		if err != "" || claims.Restaurant_id == "" || (claims.Purpose != "" && claims.Purpose != helper.ChallengeEnrollment) {
+			ctx.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Invalid or expired token",
+			})
+			ctx.Abort()
+			return
+		}
+
+		ctx.Set("auth_type", helper.AuthTypeUser)
+		ctx.Set("uid", claims.Uid)
+		ctx.Set("role", claims.Role)
+		ctx.Set("restaurant_id", claims.Restaurant_id)
+		ctx.Set("enrollment_challenge", claims.Purpose == helper.ChallengeEnrollment)
+
+		ctx.Next()
+	}
+}
+
+// Authorize must run after Authentication, it rejects callers whose role (for users)
+// or scopes (for api keys) don't include the permission
+func Authorize(permission string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		allowed := helper.HasPermission(ctx.GetString("auth_type"), ctx.GetString("role"), ctx.GetStringSlice("scopes"), permission)
+
+		if !allowed {
+			ctx.JSON(http.StatusForbidden, gin.H{
+				"error": "You are not allowed to perform this action",
+			})
+			ctx.Abort()
+			return
+		}
 
 		ctx.Next()
 	}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"resturnat-management/helper"
+	"resturnat-management/idempotency"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Idempotent makes a create request safe to retry. a request sent with an Idempotency-Key
+// header runs once per caller and key, retries within a day get the first response replayed,
+// and reusing a key for a different request is rejected. server errors aren't kept so they
+// can be retried. requests without the header run as usual, and so does everything when
+// redis is down, a duplicate is better than a till that can't take orders.
+// it must run after Authentication and Authorize
+func Idempotent() gin.HandlerFunc {
+	return idempotency.Handler(helper.IdempotencyStore{}, 5*time.Second)
+}
@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var tenantCollections = []string{"food", "menu", "table", "order", "orderItem", "invoice", "note", "user", "apiKey"}
+
+// documents written before multi-branch support have no restaurant_id, they are
+// assigned to the branch named by DEFAULT_RESTAURANT_ID
+func backfillRestaurantID(ctx context.Context, db *mongo.Database) error {
+	missing := bson.M{"restaurant_id": bson.M{"$exists": false}}
+
+	var total int64
+	for _, name := range tenantCollections {
+		count, err := db.Collection(name).CountDocuments(ctx, missing)
+		if err != nil {
+			return err
+		}
+		total += count
+	}
+	if total == 0 {
+		return nil
+	}
+
+	restaurantID := os.Getenv("DEFAULT_RESTAURANT_ID")
+	if restaurantID == "" {
+		return errors.New("existing documents have no restaurant_id, set DEFAULT_RESTAURANT_ID to the branch they belong to")
+	}
+
+	for _, name := range tenantCollections {
+		result, err := db.Collection(name).UpdateMany(ctx, missing, bson.M{"$set": bson.M{"restaurant_id": restaurantID}})
+		if err != nil {
+			return err
+		}
+		log.Printf("Assigned %d %s documents to restaurant %s", result.ModifiedCount, name, restaurantID)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// the audit log grows with every write, these back the filters of GET /audit-logs
+func auditLogIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("auditLog").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "restaurant_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "restaurant_id", Value: 1}, {Key: "entity", Value: 1}, {Key: "entity_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "restaurant_id", Value: 1}, {Key: "actor_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// order items used to keep the portion size (S, M or L) in quantity. the size moves to
+// portion and quantity becomes a count, which was always one
+func splitOrderItemQuantity(ctx context.Context, db *mongo.Database) error {
+	result, err := db.Collection("orderItem").UpdateMany(ctx,
+		bson.M{"quantity": bson.M{"$type": "string"}},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.D{
+				{Key: "portion", Value: "$quantity"},
+				{Key: "quantity", Value: 1},
+			}}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	log.Printf("Migrated quantity of %d order items", result.ModifiedCount)
+	return nil
+}
@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// order items didn't have a kitchen status before. only items without one are filled in, from
+// what their order's status says about them: items of orders still being worked on join the
+// kitchen queue, those of ready orders are ready, those of cancelled orders are voided and
+// those of served or closed orders were served
+func backfillKitchenStatus(ctx context.Context, db *mongo.Database) error {
+	statusOf := map[string][]interface{}{
+		"QUEUED": {nil, "", "PLACED", "ACCEPTED", "PREPARING"},
+		"READY":  {"READY"},
+		"VOIDED": {"CANCELLED"},
+		"SERVED": {"SERVED", "CLOSED"},
+	}
+
+	for kitchenStatus, orderStatuses := range statusOf {
+		cursor, err := db.Collection("order").Find(ctx, bson.M{"order_status": bson.M{"$in": orderStatuses}})
+		if err != nil {
+			return err
+		}
+		var orders []struct {
+			Order_id string `bson:"order_id"`
+		}
+		if err := cursor.All(ctx, &orders); err != nil {
+			return err
+		}
+		orderIds := bson.A{}
+		for _, order := range orders {
+			orderIds = append(orderIds, order.Order_id)
+		}
+		if len(orderIds) == 0 {
+			continue
+		}
+
+		result, err := db.Collection("orderItem").UpdateMany(ctx,
+			bson.M{"order_id": bson.M{"$in": orderIds}, "kitchen_status": bson.M{"$in": bson.A{nil, ""}}},
+			bson.M{"$set": bson.M{"kitchen_status": kitchenStatus}},
+		)
+		if err != nil {
+			return err
+		}
+		log.Printf("Marked %d order items %s", result.ModifiedCount, kitchenStatus)
+	}
+	return nil
+}
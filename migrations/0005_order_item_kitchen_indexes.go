@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// these back the station queue and the late item checker, which runs every minute
+func orderItemKitchenIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("orderItem").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "restaurant_id", Value: 1}, {Key: "station", Value: 1}, {Key: "kitchen_status", Value: 1}, {Key: "queued_at", Value: 1}}},
+		{Keys: bson.D{{Key: "kitchen_status", Value: 1}, {Key: "late_alerted_at", Value: 1}}},
+	})
+	return err
+}
@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// items from before courses existed all went to the kitchen straight away, so they become
+// a fired first course. fired_at is what tells a held item from a fired one
+func backfillOrderItemCourses(ctx context.Context, db *mongo.Database) error {
+	result, err := db.Collection("orderItem").UpdateMany(ctx,
+		bson.M{"fired_at": bson.M{"$exists": false}},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.D{
+				{Key: "course", Value: 1},
+				{Key: "fired_at", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$queued_at", "$created_at"}}}},
+			}}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	log.Printf("Moved %d order items into the first course", result.ModifiedCount)
+	return nil
+}
@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// orders from before order types were all at a table. the type is stored so listing orders
+// by type finds them, and indexed because the order list is filtered by it
+func backfillOrderTypes(ctx context.Context, db *mongo.Database) error {
+	orders := db.Collection("order")
+	result, err := orders.UpdateMany(ctx,
+		bson.M{"order_type": bson.M{"$in": bson.A{nil, ""}}},
+		bson.M{"$set": bson.M{"order_type": "DINE_IN"}},
+	)
+	if err != nil {
+		return err
+	}
+	log.Printf("Marked %d orders as dine-in", result.ModifiedCount)
+
+	_, err = orders.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "restaurant_id", Value: 1}, {Key: "order_type", Value: 1}},
+	})
+	return err
+}
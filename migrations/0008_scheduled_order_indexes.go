@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// these back the pre-order scheduler, which runs every minute, and the time slot capacity check
+func scheduledOrderIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("order").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "order_status", Value: 1}, {Key: "scheduled_for", Value: 1}}},
+		{Keys: bson.D{{Key: "restaurant_id", Value: 1}, {Key: "scheduled_for", Value: 1}}},
+	})
+	return err
+}
@@ -0,0 +1,16 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// backs the void report, which reads a restaurant's voided items by when they were voided
+func voidReportIndex(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("orderItem").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "restaurant_id", Value: 1}, {Key: "kitchen_status", Value: 1}, {Key: "voided_at", Value: 1}},
+	})
+	return err
+}
@@ -0,0 +1,37 @@
+package migrations
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// the order list is paged by created_at and filtered by status, table or staff. orders from
+// before created_by existed get whoever placed them, the first entry of their status history
+func orderListIndexes(ctx context.Context, db *mongo.Database) error {
+	orders := db.Collection("order")
+	result, err := orders.UpdateMany(ctx,
+		bson.M{"created_by": bson.M{"$exists": false}},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.D{
+				{Key: "created_by", Value: bson.D{{Key: "$ifNull", Value: bson.A{
+					bson.D{{Key: "$first", Value: "$status_history.changed_by"}}, "",
+				}}}},
+			}}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	log.Printf("Set who took %d orders", result.ModifiedCount)
+
+	_, err = orders.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "restaurant_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "restaurant_id", Value: 1}, {Key: "order_status", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "restaurant_id", Value: 1}, {Key: "table_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "restaurant_id", Value: 1}, {Key: "created_by", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// the relay looks up a restaurant's webhooks for every event it publishes, and may queue the
+// same event twice, which the unique index turns into one delivery. the worker reads pending
+// deliveries by when they are due and the delivery log lists them newest first
+func webhookIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("webhook").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "restaurant_id", Value: 1}, {Key: "active", Value: 1}, {Key: "event_types", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.Collection("webhookDelivery").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "webhook_id", Value: 1}, {Key: "event_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "restaurant_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "restaurant_id", Value: 1}, {Key: "webhook_id", Value: 1}, {Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
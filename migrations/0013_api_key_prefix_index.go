@@ -0,0 +1,18 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// api keys are looked up by their prefix alone, so two keys must never share one
+func apiKeyPrefixIndex(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("apiKey").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "prefix", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
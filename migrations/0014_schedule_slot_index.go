@@ -0,0 +1,18 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// every time slot has one counter, the first bookings of a slot race to insert it
+func scheduleSlotIndex(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("scheduleSlot").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "slot_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
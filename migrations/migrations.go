@@ -0,0 +1,65 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"resturnat-management/database"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type migration struct {
+	id string
+	up func(ctx context.Context, db *mongo.Database) error
+}
+
+// migrations run in order and each one only once, applied ids are kept in the migration collection.
+// append new ones to the end and never rename an id that has shipped
+var all = []migration{
+	{id: "0001_backfill_restaurant_id", up: backfillRestaurantID},
+	{id: "0002_audit_log_indexes", up: auditLogIndexes},
+	{id: "0003_order_item_quantity", up: splitOrderItemQuantity},
+	{id: "0004_order_item_kitchen_status", up: backfillKitchenStatus},
+	{id: "0005_order_item_kitchen_indexes", up: orderItemKitchenIndexes},
+	{id: "0006_order_item_courses", up: backfillOrderItemCourses},
+	{id: "0007_order_types", up: backfillOrderTypes},
+	{id: "0008_scheduled_order_indexes", up: scheduledOrderIndexes},
+	{id: "0009_void_report_index", up: voidReportIndex},
+	{id: "0010_order_list_indexes", up: orderListIndexes},
+	{id: "0011_outbox_indexes", up: outboxIndexes},
+	{id: "0012_webhook_indexes", up: webhookIndexes},
+	{id: "0013_api_key_prefix_index", up: apiKeyPrefixIndex},
+	{id: "0014_schedule_slot_index", up: scheduleSlotIndex},
+}
+
+// Run applies every migration that hasn't been applied yet
+func Run() error {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	db := database.Client.Database(database.DatabaseName())
+	applied := db.Collection("migration")
+
+	for _, m := range all {
+		count, err := applied.CountDocuments(ctx, bson.M{"migration_id": m.id})
+		if err != nil {
+			return fmt.Errorf("checking migration %s: %w", m.id, err)
+		}
+		if count > 0 {
+			continue
+		}
+
+		log.Printf("Running migration %s", m.id)
+		if err := m.up(ctx, db); err != nil {
+			return fmt.Errorf("migration %s failed: %w", m.id, err)
+		}
+
+		if _, err := applied.InsertOne(ctx, bson.M{"migration_id": m.id, "applied_at": time.Now()}); err != nil {
+			return fmt.Errorf("recording migration %s: %w", m.id, err)
+		}
+	}
+	return nil
+}
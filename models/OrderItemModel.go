@@ -7,12 +7,56 @@ import (
 )
 
 type OrderItem struct {
-	ID           primitive.ObjectID `bson:"_id"`
-	Quantity     *string            `json:"quantity" validate:"required,eq=S|eq=M|eq=L"`
-	Unit_Price   *float64           `json:"unit_price" validate:"required"`
-	Created_at   time.Time          `json:"created_at"`
-	Updated_at   time.Time          `json:"updated_at"`
-	Food_id      *string            `json:"food_id" validate:"required"`
-	Order_id     string             `json:"order_id" validate:"required"`
-	OrderItem_id string             `json:"order_item_id"`
+	ID                primitive.ObjectID `bson:"_id"`
+	Quantity          *int               `json:"quantity" validate:"required,min=1"`
+	Portion           *string            `json:"portion" validate:"omitempty,eq=S|eq=M|eq=L"`
+	Unit_Price        *float64           `json:"unit_price" validate:"required"`
+	Catalog_price     *float64           `json:"catalog_price"`
+	Price_override_by *string            `json:"price_override_by"`
+	Tax_rate          *float64           `json:"tax_rate"`
+	Discount          *Discount          `json:"discount"`
+	Created_at        time.Time          `json:"created_at"`
+	Updated_at        time.Time          `json:"updated_at"`
+	Food_id           *string            `json:"food_id" validate:"required"`
+	Variant_id        *string            `json:"variant_id"`
+	Variant_name      *string            `json:"variant_name"`
+	Modifiers         []SelectedModifier `json:"modifiers"`
+	Station           *string            `json:"station"`
+	// the kitchen status only changes through the kitchen status endpoint, see KitchenTransitions
+	Kitchen_status string     `json:"kitchen_status"`
+	Queued_at      *time.Time `json:"queued_at"`
+	Cooking_at     *time.Time `json:"cooking_at"`
+	Ready_at       *time.Time `json:"ready_at"`
+	Served_at      *time.Time `json:"served_at"`
+	Voided_at      *time.Time `json:"voided_at"`
+	// why the item was voided and by whom, see VoidReasons. an item the kitchen had already
+	// started on also records the manager who approved the void
+	Void_reason      *string `json:"void_reason"`
+	Void_note        *string `json:"void_note"`
+	Voided_by        *string `json:"voided_by"`
+	Void_approved_by *string `json:"void_approved_by"`
+	// the prep target in force when the item was ordered, and how long it took from queued to ready
+	Prep_target_minutes *int   `json:"prep_target_minutes"`
+	Prep_seconds        *int64 `json:"prep_seconds"`
+	// set once the item ran over its prep target and the kitchen was alerted
+	Late_alerted_at *time.Time `json:"late_alerted_at"`
+	// courses go to the kitchen in turn. the first course of an order is fired right away,
+	// later ones are held with no Fired_at until a waiter fires them
+	Course   int        `json:"course" validate:"omitempty,min=1"`
+	Fired_at *time.Time `json:"fired_at"`
+	// every order the item was moved off, oldest first
+	Order_history []OrderItemMove `json:"order_history"`
+	Order_id      string          `json:"order_id" validate:"required"`
+	OrderItem_id  string          `json:"order_item_id"`
+	Restaurant_id string          `json:"restaurant_id"`
+}
+
+// SelectedModifier is a modifier option picked for an order item. only the ids are sent,
+// the names and price delta are copied from the food when the item is priced
+type SelectedModifier struct {
+	Group_id    string  `json:"group_id"`
+	Option_id   string  `json:"option_id"`
+	Group_name  string  `json:"group_name"`
+	Name        string  `json:"name"`
+	Price_delta float64 `json:"price_delta"`
 }
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditLog is an append-only record of one create, update or delete
+type AuditLog struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	Audit_id      string             `json:"audit_id"`
+	Restaurant_id string             `json:"restaurant_id"`
+	Actor_id      string             `json:"actor_id"`
+	Actor_type    string             `json:"actor_type"`
+	Entity        string             `json:"entity"`
+	Entity_id     string             `json:"entity_id"`
+	Action        string             `json:"action"`
+	Changes       []FieldChange      `json:"changes"`
+	Created_at    time.Time          `json:"created_at"`
+}
+
+type FieldChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
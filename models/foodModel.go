@@ -11,8 +11,54 @@ type Food struct {
 	Name       *string            `json:"name" validate:"required,min=2,max=100"`
 	Price      *float64           `json:"price" validate:"required,"`
 	Food_image *string            `json:"food_image" validate:"required"`
-	Created_at time.Time          `json:"created_at"`
-	Updated_at time.Time          `json:"updated_at"`
-	Food_id    string             `json:"food_id"`
-	Menu_id    *string            `json:"menu_id" validate:"required"`
+	Available  *bool              `json:"available"`
+	// overrides the restaurant's tax rate for this food, e.g. for drinks
+	Tax_rate *float64 `json:"tax_rate" validate:"omitempty,min=0,max=100"`
+	// the kitchen station that prepares the food, its order items show up on that station's feed
+	Station *string `json:"station" validate:"omitempty,eq=grill|eq=fryer|eq=bar|eq=dessert"`
+	// how long the kitchen should take, it overrides the station's target
+	Prep_minutes    *int            `json:"prep_minutes" validate:"omitempty,min=1"`
+	Created_at      time.Time       `json:"created_at"`
+	Updated_at      time.Time       `json:"updated_at"`
+	Food_id         string          `json:"food_id"`
+	Menu_id         *string         `json:"menu_id" validate:"required"`
+	Variants        []FoodVariant   `json:"variants" validate:"omitempty,dive"`
+	Modifier_groups []ModifierGroup `json:"modifier_groups" validate:"omitempty,dive"`
+	Restaurant_id   string          `json:"restaurant_id"`
+}
+
+// FoodVariant is a size or version of a food with its own price, e.g. a small or large pizza
+type FoodVariant struct {
+	Variant_id string   `json:"variant_id"`
+	Name       *string  `json:"name" validate:"required,min=1,max=50"`
+	Price      *float64 `json:"price" validate:"required,gt=0"`
+	Sku        *string  `json:"sku"`
+	Available  *bool    `json:"available"`
+}
+
+// how many options of a modifier group a guest may pick
+const (
+	ModifierSingle = "SINGLE"
+	ModifierMulti  = "MULTI"
+)
+
+// ModifierGroup is a choice a guest makes about a food, e.g. toppings or how a steak is cooked.
+// a SINGLE group takes at most one option, a MULTI group up to Max_choices where 0 means no limit.
+// a Min_choices above 0 makes the group required
+type ModifierGroup struct {
+	Group_id    string           `json:"group_id"`
+	Name        *string          `json:"name" validate:"required,min=1,max=50"`
+	Selection   string           `json:"selection" validate:"eq=SINGLE|eq=MULTI"`
+	Min_choices int              `json:"min_choices" validate:"min=0"`
+	Max_choices int              `json:"max_choices" validate:"min=0"`
+	Options     []ModifierOption `json:"options" validate:"required,min=1,dive"`
+}
+
+// ModifierOption is one pick of a group, its price delta is added to the item's unit price
+// and may be negative, e.g. for leaving something out
+type ModifierOption struct {
+	Option_id   string  `json:"option_id"`
+	Name        *string `json:"name" validate:"required,min=1,max=50"`
+	Price_delta float64 `json:"price_delta"`
+	Available   *bool   `json:"available"`
 }
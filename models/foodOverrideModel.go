@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FoodOverride holds a branch's changes to a head office food, a nil field means
+// the branch inherits whatever the head office has set. foods with variants can't have
+// their price overridden since each variant is priced on its own
+type FoodOverride struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	Food_id       string             `json:"food_id"`
+	Restaurant_id string             `json:"restaurant_id"`
+	Price         *float64           `json:"price" validate:"omitempty,gt=0"`
+	Available     *bool              `json:"available"`
+	Food_image    *string            `json:"food_image"`
+	Created_at    time.Time          `json:"created_at"`
+	Updated_at    time.Time          `json:"updated_at"`
+}
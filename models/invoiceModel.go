@@ -1,18 +1,23 @@
 package models
 
 import (
+	"resturnat-management/pricing"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Invoice struct {
-	ID               primitive.ObjectID `bson:"_id"`
-	Invoice_id       string             `json:"invoice_id" validate:"required"`
-	Order_id         string             `json:"order_id" validate:"required"`
-	Payment_method   *string            `json:"payment_method" validate:"eq=CARD|eq=CASH|eq="`
-	Payment_status   *string            `json:"payment_status" validate:"required,eq=PENDING|eq=COMPLETED|eq=FAILED"`
-	Payment_due_date time.Time          `json:"payment_due_date" validate:"required"`
-	Created_at       time.Time          `json:"created_at"`
-	Updated_at       time.Time          `json:"updated_at"`
+	ID             primitive.ObjectID `bson:"_id"`
+	Invoice_id     string             `json:"invoice_id" validate:"required"`
+	Order_id       string             `json:"order_id" validate:"required"`
+	Payment_method *string            `json:"payment_method" validate:"eq=CARD|eq=CASH|eq="`
+	// invoices of an order merged into another one are set to VOIDED by the merge, clients can't send it
+	Payment_status   *string   `json:"payment_status" validate:"required,eq=PENDING|eq=COMPLETED|eq=FAILED"`
+	Payment_due_date time.Time `json:"payment_due_date" validate:"required"`
+	Created_at       time.Time `json:"created_at"`
+	Updated_at       time.Time `json:"updated_at"`
+	Restaurant_id    string    `json:"restaurant_id"`
+	// what the order came to when the invoice was last priced, kept up to date until it is paid
+	Totals *pricing.Breakdown `json:"totals"`
 }
@@ -0,0 +1,60 @@
+package models
+
+// where an order item is in the kitchen
+const (
+	KitchenQueued  = "QUEUED"
+	KitchenCooking = "COOKING"
+	KitchenReady   = "READY"
+	KitchenServed  = "SERVED"
+	KitchenVoided  = "VOIDED"
+)
+
+// KitchenTransitions lists the statuses each kitchen status may move to, SERVED and VOIDED are final
+var KitchenTransitions = map[string][]string{
+	KitchenQueued:  {KitchenCooking, KitchenVoided},
+	KitchenCooking: {KitchenReady, KitchenVoided},
+	KitchenReady:   {KitchenServed, KitchenVoided},
+	KitchenServed:  {},
+	KitchenVoided:  {},
+}
+
+// prep stations a food can be routed to
+const (
+	StationGrill   = "grill"
+	StationFryer   = "fryer"
+	StationBar     = "bar"
+	StationDessert = "dessert"
+)
+
+var Stations = []string{StationGrill, StationFryer, StationBar, StationDessert}
+
+// CurrentKitchenStatus treats items ordered before kitchen statuses existed as queued
+func (o OrderItem) CurrentKitchenStatus() string {
+	if o.Kitchen_status == "" {
+		return KitchenQueued
+	}
+	return o.Kitchen_status
+}
+
+func IsValidKitchenStatus(status string) bool {
+	_, ok := KitchenTransitions[status]
+	return ok
+}
+
+func CanTransitionKitchen(from, to string) bool {
+	for _, next := range KitchenTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+func IsValidStation(station string) bool {
+	for _, known := range Stations {
+		if known == station {
+			return true
+		}
+	}
+	return false
+}
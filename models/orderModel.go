@@ -10,11 +10,67 @@ type Order struct {
 	ID         primitive.ObjectID `bson:"_id"`
 	Order_id   string             `json:"order_id"`
 	Order_date time.Time          `json:"order_date" validate:"required"`
-	Table_id   *string            `json:"table_id" validate:"required"`
+	// only dine-in orders have a table, see checkOrderType
+	Order_type  string     `json:"order_type" validate:"omitempty,eq=DINE_IN|eq=TAKEAWAY|eq=DELIVERY"`
+	Table_id    *string    `json:"table_id"`
+	Customer    *Customer  `json:"customer"`
+	Promised_at *time.Time `json:"promised_at"`
+	// a pre-order stays SCHEDULED until the restaurant's lead time before this
+	Scheduled_for *time.Time `json:"scheduled_for"`
+	// the slot the pre-order holds a place in, only set when its restaurant limits its slots
+	Slot_id *string `json:"slot_id"`
 	// Food_id      *string            `json:"food_id" validate:"required"`
 	// Quantity     *int               `json:"quantity" validate:"required,min=1"`
 	// Price        *float64           `json:"price" validate:"required"`
-	// Order_Status *string            `json:"order_status" validate:"required,eq=PENDING|eq=COMPLETED|eq=CANCELLED"`
-	Created_at time.Time `json:"created_at"`
-	Updated_at time.Time `json:"updated_at"`
+	// the status only changes through the transition endpoint, see OrderTransitions
+	Order_status   string              `json:"order_status"`
+	Status_history []OrderStatusChange `json:"status_history"`
+	Discount       *Discount           `json:"discount"`
+	// why a CANCELLED order was cancelled and by whom, see VoidReasons
+	Cancel_reason *string `json:"cancel_reason"`
+	Cancel_note   *string `json:"cancel_note"`
+	Cancelled_by  *string `json:"cancelled_by"`
+	// set on orders split off another one, and on orders merged into another one
+	Split_from  *string   `json:"split_from"`
+	Merged_into *string   `json:"merged_into"`
+	Created_at  time.Time `json:"created_at"`
+	// the user or api key that took the order
+	Created_by    string    `json:"created_by"`
+	Updated_at    time.Time `json:"updated_at"`
+	Restaurant_id string    `json:"restaurant_id"`
+}
+
+// Customer is who a takeaway or delivery order is for, a delivery needs all three
+type Customer struct {
+	Name    *string `json:"name"`
+	Phone   *string `json:"phone"`
+	Address *string `json:"address"`
+}
+
+// Discount takes a PERCENT or a fixed AMOUNT off an order item or a whole order
+type Discount struct {
+	Type  string  `json:"type"`
+	Value float64 `json:"value"`
+}
+
+// why an order item changed orders
+const (
+	ItemMoved  = "move"
+	ItemSplit  = "split"
+	ItemMerged = "merge"
+)
+
+// OrderItemMove records an order item changing orders
+type OrderItemMove struct {
+	From_order_id string    `json:"from_order_id"`
+	To_order_id   string    `json:"to_order_id"`
+	Reason        string    `json:"reason"`
+	Moved_at      time.Time `json:"moved_at"`
+	Moved_by      string    `json:"moved_by"`
+}
+
+type OrderStatusChange struct {
+	Status     string    `json:"status"`
+	Changed_at time.Time `json:"changed_at"`
+	Changed_by string    `json:"changed_by"`
 }
@@ -0,0 +1,81 @@
+package models
+
+const (
+	// a pre-order, the scheduler places it when it is time to start cooking
+	OrderScheduled = "SCHEDULED"
+	OrderPlaced    = "PLACED"
+	OrderAccepted  = "ACCEPTED"
+	OrderPreparing = "PREPARING"
+	OrderReady     = "READY"
+	OrderServed    = "SERVED"
+	OrderClosed    = "CLOSED"
+	OrderCancelled = "CANCELLED"
+	// the order's items were merged into another order, only MergeOrders sets it
+	OrderMerged = "MERGED"
+)
+
+// OrderTransitions is the lifecycle of an order, each status lists the statuses it may move to.
+// CLOSED, CANCELLED and MERGED are final
+var OrderTransitions = map[string][]string{
+	OrderScheduled: {OrderPlaced, OrderCancelled},
+	OrderPlaced:    {OrderAccepted, OrderCancelled},
+	OrderAccepted:  {OrderPreparing, OrderCancelled},
+	OrderPreparing: {OrderReady, OrderCancelled},
+	OrderReady:     {OrderServed, OrderCancelled},
+	OrderServed:    {OrderClosed},
+	OrderClosed:    {},
+	OrderCancelled: {},
+	OrderMerged:    {},
+}
+
+// CurrentStatus treats orders created before statuses existed as just placed
+func (o Order) CurrentStatus() string {
+	if o.Order_status == "" {
+		return OrderPlaced
+	}
+	return o.Order_status
+}
+
+func IsValidOrderStatus(status string) bool {
+	_, ok := OrderTransitions[status]
+	return ok
+}
+
+func CanTransitionOrder(from, to string) bool {
+	for _, next := range OrderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// OrderPath is the shortest run of transitions that takes an order from one status to
+// another without cancelling it, nil when there is none
+func OrderPath(from, to string) []string {
+	previous := map[string]string{from: ""}
+	queue := []string{from}
+	for len(queue) > 0 {
+		status := queue[0]
+		queue = queue[1:]
+		if status == to {
+			path := []string{}
+			for ; status != from; status = previous[status] {
+				path = append([]string{status}, path...)
+			}
+			return path
+		}
+		for _, next := range OrderTransitions[status] {
+			if _, seen := previous[next]; seen || next == OrderCancelled || next == OrderMerged {
+				continue
+			}
+			previous[next] = status
+			queue = append(queue, next)
+		}
+	}
+	return nil
+}
+
+func IsFinalOrderStatus(status string) bool {
+	return len(OrderTransitions[status]) == 0
+}
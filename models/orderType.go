@@ -0,0 +1,20 @@
+package models
+
+// an order is eaten in at a table, picked up at the counter or delivered
+const (
+	OrderDineIn   = "DINE_IN"
+	OrderTakeaway = "TAKEAWAY"
+	OrderDelivery = "DELIVERY"
+)
+
+// CurrentType treats orders created before order types existed as dine-in
+func (o Order) CurrentType() string {
+	if o.Order_type == "" {
+		return OrderDineIn
+	}
+	return o.Order_type
+}
+
+func IsValidOrderType(orderType string) bool {
+	return orderType == OrderDineIn || orderType == OrderTakeaway || orderType == OrderDelivery
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// kinds of outbox event. status changes are named after the new status, see OrderStatusEvent
+// and KitchenStatusEvent
+const (
+	EventOrderCreated     = "order.created"
+	EventOrderUpdated     = "order.updated"
+	EventOrderItemCreated = "order_item.created"
+	EventOrderItemUpdated = "order_item.updated"
+	// a held item's course was sent to the kitchen
+	EventOrderItemFired = "order_item.fired"
+	// the item was moved to another order by a move, split or merge
+	EventOrderItemMoved = "order_item.moved"
+	// the item ran over its prep target
+	EventOrderItemLate  = "order_item.late"
+	EventInvoiceCreated = "invoice.created"
+	EventInvoiceUpdated = "invoice.updated"
+	// the invoice's payment status became COMPLETED
+	EventInvoicePaid = "invoice.paid"
+)
+
+// OrderStatusEvent names the event of an order moving to a status, like order.closed
+func OrderStatusEvent(status string) string {
+	return "order." + strings.ToLower(status)
+}
+
+// KitchenStatusEvent names the event of an item moving to a kitchen status, like order_item.ready
+func KitchenStatusEvent(status string) string {
+	return "order_item." + strings.ToLower(status)
+}
+
+// EventTypes lists every kind of outbox event, webhooks subscribe to them
+func EventTypes() []string {
+	types := []string{
+		EventOrderCreated, EventOrderUpdated,
+		EventOrderItemCreated, EventOrderItemUpdated, EventOrderItemFired, EventOrderItemMoved, EventOrderItemLate,
+		EventInvoiceCreated, EventInvoiceUpdated, EventInvoicePaid,
+	}
+	for status := range OrderTransitions {
+		types = append(types, OrderStatusEvent(status))
+	}
+	// new items are order_item.created, nothing moves an item back to queued
+	for status := range KitchenTransitions {
+		if status != KitchenQueued {
+			types = append(types, KitchenStatusEvent(status))
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// OutboxEvent is a change to an order, order item or invoice. it is written in the same
+// transaction as the change, and the relay copies it to the restaurant's event stream
+type OutboxEvent struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	Event_id      string             `json:"event_id"`
+	Type          string             `json:"type"`
+	Entity        string             `json:"entity"`
+	Entity_id     string             `json:"entity_id"`
+	Restaurant_id string             `json:"restaurant_id"`
+	// the entity as it was right after the change
+	Data       bson.M    `json:"data"`
+	Created_at time.Time `json:"created_at"`
+	// set once the event is on the stream, together with the id redis gave it there
+	Published_at *time.Time `json:"published_at"`
+	Stream_id    *string    `json:"stream_id"`
+	// a relay holds the event until then while it publishes it
+	Locked_until *time.Time `json:"locked_until"`
+	Attempts     int        `json:"attempts"`
+	Last_error   *string    `json:"last_error"`
+}
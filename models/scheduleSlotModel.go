@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ScheduleSlot counts the pre-orders that hold a place in one time slot of a restaurant with a
+// slot capacity. a place is taken in the same transaction that inserts the order, only while
+// Count is below the capacity, and given back when the order is cancelled or merged
+type ScheduleSlot struct {
+	ID primitive.ObjectID `bson:"_id"`
+	// the restaurant, the slot's start and its length, see SlotId
+	Slot_id       string    `json:"slot_id"`
+	Restaurant_id string    `json:"restaurant_id"`
+	Start         time.Time `json:"start"`
+	Minutes       int       `json:"minutes"`
+	Count         int       `json:"count"`
+}
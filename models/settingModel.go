@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Setting holds the per-restaurant policies, there is at most one per restaurant
+type Setting struct {
+	ID                 primitive.ObjectID `bson:"_id"`
+	Restaurant_id      string             `json:"restaurant_id"`
+	Mfa_required_roles []string           `json:"mfa_required_roles"`
+	// pricing policy, see the pricing package. rates are percentages
+	Tax_mode                string   `json:"tax_mode"`
+	Tax_rate                *float64 `json:"tax_rate"`
+	Service_charge_rate     *float64 `json:"service_charge_rate"`
+	Service_charge_tax_rate *float64 `json:"service_charge_tax_rate"`
+	Rounding_increment      *float64 `json:"rounding_increment"`
+	// target prep time per kitchen station, foods can set their own
+	Station_prep_minutes map[string]int `json:"station_prep_minutes"`
+	// pre-orders go to the kitchen this long before they are due, and at most
+	// Schedule_slot_capacity of them can be due in one slot. a capacity of 0 is no limit
+	Schedule_lead_minutes  *int      `json:"schedule_lead_minutes"`
+	Schedule_slot_minutes  *int      `json:"schedule_slot_minutes"`
+	Schedule_slot_capacity *int      `json:"schedule_slot_capacity"`
+	Created_at             time.Time `json:"created_at"`
+	Updated_at             time.Time `json:"updated_at"`
+}
@@ -10,13 +10,36 @@ type User struct {
 	ID        primitive.ObjectID `bson:"_id"`
 	FirstName *string            `json:"first_name" validate:"required,min=2,max=100"`
 	LastName  *string            `json:"last_name" validate:"required,min=2,max=100"`
-	Password  *string            `json:"password" validate:"required,min=6"`
-	Email     *string            `json:"email" validate:"email,required"`
+	// only ever read from a request, the stored hash never goes out in a response
+	Password *string `json:"password,omitempty" validate:"required,min=6"`
+	Email    *string `json:"email" validate:"email,required"`
 	// Avatar        *string            `json:"avatar" validate:"required"`
-	Phone         *string   `json:"phone" validate:"required"`
-	Token         *string   `json:"token"`
-	Refresh_Token *string   `json:"refresh_token"`
-	Created_at    time.Time `json:"created_at"`
-	Updated_at    time.Time `json:"updated_at"`
-	User_id       string    `json:"user_id"`
+	Phone *string `json:"phone" validate:"required"`
+	Role  *string `json:"role"`
+	// the totp secret is stored as soon as enrollment starts but only used once totp_enabled is set
+	Totp_enabled   bool     `json:"totp_enabled"`
+	Totp_secret    *string  `json:"-"`
+	Totp_last_step int64    `json:"-"`
+	Recovery_codes []string `json:"-"`
+	// wrong codes at the second login step since the last right one, too many lock it for a while
+	Totp_failed_attempts int        `json:"-"`
+	Totp_locked_until    *time.Time `json:"-"`
+	// managers approve voids at the till with this pin, it is locked after too many wrong tries
+	Approval_pin          *string   `json:"-"`
+	Approval_pin_failures int       `json:"-"`
+	Token                 *string   `json:"-"`
+	Refresh_Token         *string   `json:"-"`
+	Created_at            time.Time `json:"created_at"`
+	Updated_at            time.Time `json:"updated_at"`
+	User_id               string    `json:"user_id"`
+	Restaurant_id         string    `json:"restaurant_id" validate:"required"`
+}
+
+// Public is the user without the password hash, approval pin and tokens, as it is shown or audited
+func (u User) Public() User {
+	u.Password = nil
+	u.Approval_pin = nil
+	u.Token = nil
+	u.Refresh_Token = nil
+	return u
 }
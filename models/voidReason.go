@@ -0,0 +1,30 @@
+package models
+
+// why an order was cancelled or an order item voided
+const (
+	VoidCustomerRequest = "CUSTOMER_REQUEST"
+	VoidWrongItem       = "WRONG_ITEM"
+	VoidKitchenError    = "KITCHEN_ERROR"
+	VoidOutOfStock      = "OUT_OF_STOCK"
+	VoidQualityIssue    = "QUALITY_ISSUE"
+	// needs a note saying what happened
+	VoidOther = "OTHER"
+)
+
+var VoidReasons = []string{VoidCustomerRequest, VoidWrongItem, VoidKitchenError, VoidOutOfStock, VoidQualityIssue, VoidOther}
+
+func IsValidVoidReason(reason string) bool {
+	for _, known := range VoidReasons {
+		if known == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// VoidNeedsApproval reports whether voiding the item needs a manager, which is once the
+// kitchen has started on it
+func (o OrderItem) VoidNeedsApproval() bool {
+	status := o.CurrentKitchenStatus()
+	return status == KitchenCooking || status == KitchenReady
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// where a webhook delivery stands
+const (
+	// waiting for its next attempt
+	DeliveryPending   = "PENDING"
+	DeliveryDelivered = "DELIVERED"
+	// the dead letters: out of attempts, or its webhook was deleted or turned off
+	DeliveryDead = "DEAD"
+)
+
+// Webhook subscribes a url to some kinds of order, order item and invoice event
+type Webhook struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Webhook_id  string             `json:"webhook_id"`
+	Url         string             `json:"url"`
+	Event_types []string           `json:"event_types"`
+	// signs the deliveries, it is only ever returned when it is set
+	Secret        string    `json:"-"`
+	Active        bool      `json:"active"`
+	Created_by    string    `json:"created_by"`
+	Created_at    time.Time `json:"created_at"`
+	Updated_at    time.Time `json:"updated_at"`
+	Restaurant_id string    `json:"restaurant_id"`
+}
+
+// WebhookDelivery is one event on its way to one webhook, along with every attempt at it
+type WebhookDelivery struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Delivery_id string             `json:"delivery_id"`
+	Webhook_id  string             `json:"webhook_id"`
+	Event_id    string             `json:"event_id"`
+	Event_type  string             `json:"event_type"`
+	// what is sent, the same on every attempt
+	Body            string           `json:"body"`
+	Status          string           `json:"status"`
+	Attempts        int              `json:"attempts"`
+	Next_attempt_at *time.Time       `json:"next_attempt_at"`
+	Delivered_at    *time.Time       `json:"delivered_at"`
+	Last_error      *string          `json:"last_error"`
+	Attempt_log     []WebhookAttempt `json:"attempt_log"`
+	// a worker holds the delivery until then while it sends it
+	Locked_until  *time.Time `json:"-"`
+	Created_at    time.Time  `json:"created_at"`
+	Updated_at    time.Time  `json:"updated_at"`
+	Restaurant_id string     `json:"restaurant_id"`
+}
+
+type WebhookAttempt struct {
+	Attempted_at time.Time `json:"attempted_at"`
+	Url          string    `json:"url"`
+	Status_code  int       `json:"status_code"`
+	// the start of the receiver's answer, only kept when the attempt failed
+	Response    string  `json:"response"`
+	Error       *string `json:"error"`
+	Duration_ms int64   `json:"duration_ms"`
+}
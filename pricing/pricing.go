@@ -0,0 +1,293 @@
+// Package pricing turns order lines into a bill: subtotal, discounts, service charge, tax
+// per rate, rounding and the grand total. it doesn't touch the database, so invoices and
+// order previews always come out with the same numbers.
+//
+// all the arithmetic is done in cents so a bill adds up to the cent however it is split
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// how menu prices relate to tax
+const (
+	// prices are before tax, tax is added on top
+	TaxExclusive = "EXCLUSIVE"
+	// prices already contain the tax, it is only split out on the bill
+	TaxInclusive = "INCLUSIVE"
+)
+
+// kinds of discount
+const (
+	DiscountPercent = "PERCENT"
+	DiscountAmount  = "AMOUNT"
+)
+
+type Discount struct {
+	Type  string  `json:"type"`
+	Value float64 `json:"value"`
+}
+
+// Line is one ordered item. a nil Tax_rate uses the rate from the Config
+type Line struct {
+	Ref        string    `json:"ref"`
+	Quantity   int       `json:"quantity"`
+	Unit_price float64   `json:"unit_price"`
+	Tax_rate   *float64  `json:"tax_rate"`
+	Discount   *Discount `json:"discount"`
+}
+
+// Config is the restaurant's pricing policy, rates are percentages
+type Config struct {
+	Tax_mode                string  `json:"tax_mode"`
+	Tax_rate                float64 `json:"tax_rate"`
+	Service_charge_rate     float64 `json:"service_charge_rate"`
+	Service_charge_tax_rate float64 `json:"service_charge_tax_rate"`
+	// the grand total is rounded to a multiple of this, e.g. 0.05. zero leaves it alone
+	Rounding_increment float64 `json:"rounding_increment"`
+}
+
+type LineTotal struct {
+	Ref        string  `json:"ref"`
+	Quantity   int     `json:"quantity"`
+	Unit_price float64 `json:"unit_price"`
+	// quantity x unit price
+	Amount   float64 `json:"amount"`
+	Discount float64 `json:"discount"`
+	// the line's share of the order discount
+	Order_discount float64 `json:"order_discount"`
+	// what the line is charged after discounts, tax included in inclusive mode
+	Net      float64 `json:"net"`
+	Tax_rate float64 `json:"tax_rate"`
+}
+
+type TaxTotal struct {
+	Rate    float64 `json:"rate"`
+	Taxable float64 `json:"taxable"`
+	Tax     float64 `json:"tax"`
+}
+
+type Breakdown struct {
+	Tax_mode string      `json:"tax_mode"`
+	Lines    []LineTotal `json:"lines"`
+	// sum of the line amounts before any discount
+	Subtotal       float64    `json:"subtotal"`
+	Line_discounts float64    `json:"line_discounts"`
+	Order_discount float64    `json:"order_discount"`
+	Service_charge float64    `json:"service_charge"`
+	Taxes          []TaxTotal `json:"taxes"`
+	Tax_total      float64    `json:"tax_total"`
+	Rounding       float64    `json:"rounding"`
+	Grand_total    float64    `json:"grand_total"`
+}
+
+// CheckDiscount reports what is wrong with a discount, nil means none is applied
+func CheckDiscount(discount *Discount) error {
+	if discount == nil {
+		return nil
+	}
+	switch discount.Type {
+	case DiscountPercent:
+		if discount.Value < 0 || discount.Value > 100 {
+			return errors.New("a percent discount must be between 0 and 100")
+		}
+	case DiscountAmount:
+		if discount.Value < 0 {
+			return errors.New("a discount amount can't be negative")
+		}
+	default:
+		return fmt.Errorf("discount type must be %s or %s", DiscountPercent, DiscountAmount)
+	}
+	return nil
+}
+
+// CheckConfig reports what is wrong with a pricing policy
+func CheckConfig(config Config) error {
+	if config.Tax_mode != "" && config.Tax_mode != TaxExclusive && config.Tax_mode != TaxInclusive {
+		return fmt.Errorf("tax_mode must be %s or %s", TaxExclusive, TaxInclusive)
+	}
+	for _, rate := range []float64{config.Tax_rate, config.Service_charge_rate, config.Service_charge_tax_rate} {
+		if rate < 0 || rate > 100 {
+			return errors.New("rates must be between 0 and 100")
+		}
+	}
+	if config.Rounding_increment < 0 {
+		return errors.New("rounding_increment can't be negative")
+	}
+	return nil
+}
+
+// Compute prices the lines. line discounts come first, the order discount is then spread
+// over the lines in proportion to what is left of them so every tax rate gets its share.
+// the service charge is a percentage of the pre-tax total and is taxed on top at its own rate
+func Compute(lines []Line, orderDiscount *Discount, config Config) (Breakdown, error) {
+	if err := CheckConfig(config); err != nil {
+		return Breakdown{}, err
+	}
+	if err := CheckDiscount(orderDiscount); err != nil {
+		return Breakdown{}, err
+	}
+	mode := config.Tax_mode
+	if mode == "" {
+		mode = TaxExclusive
+	}
+
+	breakdown := Breakdown{Tax_mode: mode, Lines: []LineTotal{}, Taxes: []TaxTotal{}}
+	amounts := make([]int64, len(lines))
+	nets := make([]int64, len(lines))
+	var subtotal, lineDiscounts, afterLineDiscounts int64
+
+	for i, line := range lines {
+		if line.Quantity < 1 {
+			return Breakdown{}, fmt.Errorf("line %d: quantity must be at least 1", i)
+		}
+		if line.Unit_price < 0 {
+			return Breakdown{}, fmt.Errorf("line %d: unit price can't be negative", i)
+		}
+		if line.Tax_rate != nil && (*line.Tax_rate < 0 || *line.Tax_rate > 100) {
+			return Breakdown{}, fmt.Errorf("line %d: tax rate must be between 0 and 100", i)
+		}
+		if err := CheckDiscount(line.Discount); err != nil {
+			return Breakdown{}, fmt.Errorf("line %d: %w", i, err)
+		}
+
+		amounts[i] = toCents(line.Unit_price) * int64(line.Quantity)
+		discount := discountCents(amounts[i], line.Discount)
+		nets[i] = amounts[i] - discount
+
+		subtotal += amounts[i]
+		lineDiscounts += discount
+		afterLineDiscounts += nets[i]
+	}
+
+	orderOff := discountCents(afterLineDiscounts, orderDiscount)
+	shares := spread(orderOff, nets)
+
+	// lines are grouped by tax rate and taxed per group, not per line, so the tax
+	// doesn't pick up a rounding error from every line
+	bases := map[float64]int64{}
+	for i, line := range lines {
+		nets[i] -= shares[i]
+		rate := config.Tax_rate
+		if line.Tax_rate != nil {
+			rate = *line.Tax_rate
+		}
+		rate = math.Round(rate*1000) / 1000
+		bases[rate] += nets[i]
+
+		breakdown.Lines = append(breakdown.Lines, LineTotal{
+			Ref:            line.Ref,
+			Quantity:       line.Quantity,
+			Unit_price:     fromCents(toCents(line.Unit_price)),
+			Amount:         fromCents(amounts[i]),
+			Discount:       fromCents(amounts[i] - nets[i] - shares[i]),
+			Order_discount: fromCents(shares[i]),
+			Net:            fromCents(nets[i]),
+			Tax_rate:       rate,
+		})
+	}
+
+	taxable := map[float64]int64{}
+	taxes := map[float64]int64{}
+	var preTax int64
+	for rate, base := range bases {
+		if mode == TaxInclusive {
+			taxes[rate] = roundDiv(float64(base)*rate, 100+rate)
+			taxable[rate] = base - taxes[rate]
+		} else {
+			taxable[rate] = base
+			taxes[rate] = roundDiv(float64(base)*rate, 100)
+		}
+		preTax += taxable[rate]
+	}
+
+	serviceCharge := roundDiv(float64(preTax)*config.Service_charge_rate, 100)
+	if serviceCharge > 0 {
+		rate := math.Round(config.Service_charge_tax_rate*1000) / 1000
+		taxable[rate] += serviceCharge
+		taxes[rate] += roundDiv(float64(serviceCharge)*rate, 100)
+	}
+
+	rates := make([]float64, 0, len(taxable))
+	for rate := range taxable {
+		rates = append(rates, rate)
+	}
+	sort.Float64s(rates)
+
+	var taxTotal, total int64
+	for _, rate := range rates {
+		breakdown.Taxes = append(breakdown.Taxes, TaxTotal{Rate: rate, Taxable: fromCents(taxable[rate]), Tax: fromCents(taxes[rate])})
+		taxTotal += taxes[rate]
+		total += taxable[rate] + taxes[rate]
+	}
+
+	rounded := total
+	if increment := toCents(config.Rounding_increment); increment > 1 {
+		rounded = roundDiv(float64(total), float64(increment)) * increment
+	}
+
+	breakdown.Subtotal = fromCents(subtotal)
+	breakdown.Line_discounts = fromCents(lineDiscounts)
+	breakdown.Order_discount = fromCents(orderOff)
+	breakdown.Service_charge = fromCents(serviceCharge)
+	breakdown.Tax_total = fromCents(taxTotal)
+	breakdown.Rounding = fromCents(rounded - total)
+	breakdown.Grand_total = fromCents(rounded)
+	return breakdown, nil
+}
+
+// discountCents is how much a discount takes off an amount, never more than the amount itself
+func discountCents(amount int64, discount *Discount) int64 {
+	if discount == nil {
+		return 0
+	}
+	var off int64
+	if discount.Type == DiscountPercent {
+		off = roundDiv(float64(amount)*discount.Value, 100)
+	} else {
+		off = toCents(discount.Value)
+	}
+	return min(off, amount)
+}
+
+// spread splits total over the amounts in proportion to them, the cents lost to rounding
+// down go to the first amounts that still have room so the shares always add up to total
+func spread(total int64, amounts []int64) []int64 {
+	shares := make([]int64, len(amounts))
+	var sum int64
+	for _, amount := range amounts {
+		sum += amount
+	}
+	if total == 0 || sum == 0 {
+		return shares
+	}
+
+	left := total
+	for i, amount := range amounts {
+		shares[i] = total * amount / sum
+		left -= shares[i]
+	}
+	for i := 0; left > 0; i = (i + 1) % len(amounts) {
+		if shares[i] < amounts[i] {
+			shares[i]++
+			left--
+		}
+	}
+	return shares
+}
+
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func fromCents(cents int64) float64 {
+	return float64(cents) / 100
+}
+
+// roundDiv divides and rounds half away from zero
+func roundDiv(numerator, denominator float64) int64 {
+	return int64(math.Round(numerator / denominator))
+}
@@ -0,0 +1,189 @@
+package pricing
+
+import "testing"
+
+func rate(r float64) *float64 {
+	return &r
+}
+
+func TestComputeExclusiveTax(t *testing.T) {
+	lines := []Line{
+		{Ref: "burger", Quantity: 2, Unit_price: 10},
+		{Ref: "soda", Quantity: 1, Unit_price: 5.5, Tax_rate: rate(5)},
+	}
+	got, err := Compute(lines, nil, Config{Tax_mode: TaxExclusive, Tax_rate: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Subtotal != 25.5 {
+		t.Errorf("subtotal = %v, want 25.5", got.Subtotal)
+	}
+	want := []TaxTotal{{Rate: 5, Taxable: 5.5, Tax: 0.28}, {Rate: 10, Taxable: 20, Tax: 2}}
+	if len(got.Taxes) != len(want) {
+		t.Fatalf("taxes = %+v, want %+v", got.Taxes, want)
+	}
+	for i := range want {
+		if got.Taxes[i] != want[i] {
+			t.Errorf("taxes[%d] = %+v, want %+v", i, got.Taxes[i], want[i])
+		}
+	}
+	if got.Tax_total != 2.28 || got.Grand_total != 27.78 {
+		t.Errorf("tax total, grand total = %v, %v, want 2.28, 27.78", got.Tax_total, got.Grand_total)
+	}
+}
+
+func TestComputeInclusiveTax(t *testing.T) {
+	lines := []Line{
+		{Ref: "pizza", Quantity: 1, Unit_price: 11},
+		{Ref: "juice", Quantity: 1, Unit_price: 5.25, Tax_rate: rate(5)},
+	}
+	got, err := Compute(lines, nil, Config{Tax_mode: TaxInclusive, Tax_rate: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []TaxTotal{{Rate: 5, Taxable: 5, Tax: 0.25}, {Rate: 10, Taxable: 10, Tax: 1}}
+	for i := range want {
+		if got.Taxes[i] != want[i] {
+			t.Errorf("taxes[%d] = %+v, want %+v", i, got.Taxes[i], want[i])
+		}
+	}
+	// the tax is already in the menu prices, so it must not be added again
+	if got.Tax_total != 1.25 || got.Grand_total != 16.25 {
+		t.Errorf("tax total, grand total = %v, %v, want 1.25, 16.25", got.Tax_total, got.Grand_total)
+	}
+}
+
+func TestComputeDiscounts(t *testing.T) {
+	lines := []Line{
+		{Ref: "wings", Quantity: 3, Unit_price: 4, Discount: &Discount{Type: DiscountPercent, Value: 10}},
+		{Ref: "salad", Quantity: 1, Unit_price: 9.2},
+	}
+	got, err := Compute(lines, &Discount{Type: DiscountAmount, Value: 5}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Line_discounts != 1.2 || got.Order_discount != 5 {
+		t.Errorf("line discounts, order discount = %v, %v, want 1.2, 5", got.Line_discounts, got.Order_discount)
+	}
+	if got.Lines[0].Order_discount != 2.7 || got.Lines[0].Net != 8.1 {
+		t.Errorf("lines[0] = %+v, want an order discount of 2.7 and net 8.1", got.Lines[0])
+	}
+	if got.Lines[1].Order_discount != 2.3 || got.Lines[1].Net != 6.9 {
+		t.Errorf("lines[1] = %+v, want an order discount of 2.3 and net 6.9", got.Lines[1])
+	}
+	if got.Grand_total != 15 {
+		t.Errorf("grand total = %v, want 15", got.Grand_total)
+	}
+}
+
+func TestComputeDiscountNeverExceedsAmount(t *testing.T) {
+	lines := []Line{{Quantity: 1, Unit_price: 3, Discount: &Discount{Type: DiscountAmount, Value: 10}}}
+	got, err := Compute(lines, &Discount{Type: DiscountAmount, Value: 10}, Config{Tax_rate: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Line_discounts != 3 || got.Order_discount != 0 || got.Grand_total != 0 {
+		t.Errorf("got %+v, want the line discounted to nothing", got)
+	}
+}
+
+func TestComputeOrderDiscountSharesAddUp(t *testing.T) {
+	lines := []Line{{Quantity: 1, Unit_price: 1}, {Quantity: 1, Unit_price: 1}, {Quantity: 1, Unit_price: 1}}
+	got, err := Compute(lines, &Discount{Type: DiscountAmount, Value: 1}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var shares float64
+	for _, line := range got.Lines {
+		shares += line.Order_discount
+	}
+	if toCents(shares) != 100 {
+		t.Errorf("order discount shares add up to %v, want 1", shares)
+	}
+	if got.Grand_total != 2 {
+		t.Errorf("grand total = %v, want 2", got.Grand_total)
+	}
+}
+
+func TestComputeServiceChargeExclusive(t *testing.T) {
+	lines := []Line{{Quantity: 1, Unit_price: 100}}
+	config := Config{Tax_mode: TaxExclusive, Tax_rate: 5, Service_charge_rate: 10, Service_charge_tax_rate: 18}
+	got, err := Compute(lines, nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Service_charge != 10 {
+		t.Errorf("service charge = %v, want 10", got.Service_charge)
+	}
+	want := []TaxTotal{{Rate: 5, Taxable: 100, Tax: 5}, {Rate: 18, Taxable: 10, Tax: 1.8}}
+	for i := range want {
+		if got.Taxes[i] != want[i] {
+			t.Errorf("taxes[%d] = %+v, want %+v", i, got.Taxes[i], want[i])
+		}
+	}
+	if got.Grand_total != 116.8 {
+		t.Errorf("grand total = %v, want 116.8", got.Grand_total)
+	}
+}
+
+func TestComputeServiceChargeInclusive(t *testing.T) {
+	// the service charge is worked out on the price without its tax
+	lines := []Line{{Quantity: 1, Unit_price: 105}}
+	got, err := Compute(lines, nil, Config{Tax_mode: TaxInclusive, Tax_rate: 5, Service_charge_rate: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Service_charge != 10 || got.Tax_total != 5 || got.Grand_total != 115 {
+		t.Errorf("service charge, tax total, grand total = %v, %v, %v, want 10, 5, 115",
+			got.Service_charge, got.Tax_total, got.Grand_total)
+	}
+}
+
+func TestComputeRounding(t *testing.T) {
+	lines := []Line{{Quantity: 1, Unit_price: 10.03}}
+	got, err := Compute(lines, nil, Config{Rounding_increment: 0.05})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Rounding != 0.02 || got.Grand_total != 10.05 {
+		t.Errorf("rounding, grand total = %v, %v, want 0.02, 10.05", got.Rounding, got.Grand_total)
+	}
+}
+
+func TestComputeRejectsBadInput(t *testing.T) {
+	cases := map[string]struct {
+		lines    []Line
+		discount *Discount
+		config   Config
+	}{
+		"zero quantity":    {lines: []Line{{Quantity: 0, Unit_price: 1}}},
+		"negative price":   {lines: []Line{{Quantity: 1, Unit_price: -1}}},
+		"percent over 100": {discount: &Discount{Type: DiscountPercent, Value: 150}},
+		"unknown discount": {discount: &Discount{Type: "BOGO", Value: 1}},
+		"unknown tax mode": {config: Config{Tax_mode: "SOMETIMES"}},
+		"tax rate over 100": {
+			lines: []Line{{Quantity: 1, Unit_price: 1, Tax_rate: rate(120)}},
+		},
+	}
+	for name, tc := range cases {
+		if _, err := Compute(tc.lines, tc.discount, tc.config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestComputeNoLines(t *testing.T) {
+	got, err := Compute(nil, nil, Config{Tax_rate: 10, Service_charge_rate: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Grand_total != 0 || len(got.Lines) != 0 || len(got.Taxes) != 0 {
+		t.Errorf("got %+v, want an empty bill", got)
+	}
+}
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"resturnat-management/controller"
+	"resturnat-management/helper"
+	"resturnat-management/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ApiKeyRouter(incomingRoutes *gin.Engine) {
+	incomingRoutes.POST("/apikeys", middleware.Authorize(helper.PermApiKeysManage), controller.CreateApiKey())
+	incomingRoutes.GET("/apikeys", middleware.Authorize(helper.PermApiKeysManage), controller.GetApiKeys())
+	incomingRoutes.DELETE("/apikeys/:api_key_id", middleware.Authorize(helper.PermApiKeysManage), controller.RevokeApiKey())
+}
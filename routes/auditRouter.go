@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"resturnat-management/controller"
+	"resturnat-management/helper"
+	"resturnat-management/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func AuditRouter(incomingRoutes *gin.Engine) {
+	// the audit log is append-only, there is deliberately no route to change or delete entries
+	incomingRoutes.GET("/audit-logs", middleware.Authorize(helper.PermAuditRead), controller.GetAuditLogs())
+}
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"resturnat-management/controller"
+	"resturnat-management/helper"
+	"resturnat-management/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func EventRouter(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/events", middleware.Authorize(helper.PermEventsRead), controller.GetEvents())
+	incomingRoutes.GET("/events/groups", middleware.Authorize(helper.PermEventsRead), controller.GetEventGroups())
+	incomingRoutes.POST("/events/groups", middleware.Authorize(helper.PermEventsManage), controller.CreateEventGroup())
+	incomingRoutes.POST("/events/groups/:group/replay", middleware.Authorize(helper.PermEventsManage), controller.ReplayEventGroup())
+}
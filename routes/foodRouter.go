@@ -2,15 +2,19 @@ package routes
 
 import (
 	controller "resturnat-management/controller"
+	"resturnat-management/helper"
+	"resturnat-management/middleware"
 
 	"github.com/gin-gonic/gin"
 )
 
 func FoodRouter(incommingRoutes *gin.Engine) {
 	// Use middleware if needed
-	incommingRoutes.POST("/food", controller.CreateFood())
-	incommingRoutes.GET("/food/:id", controller.GetFood())
-	incommingRoutes.GET("/foods", controller.GetAllFoods())
-	incommingRoutes.PATCH("/food/:id", controller.UpdateFood())
+	incommingRoutes.POST("/food", middleware.Authorize(helper.PermMenuWrite), controller.CreateFood())
+	incommingRoutes.GET("/food/:id", middleware.Authorize(helper.PermMenuRead), controller.GetFood())
+	incommingRoutes.GET("/foods", middleware.Authorize(helper.PermMenuRead), controller.GetAllFoods())
+	incommingRoutes.PATCH("/food/:id", middleware.Authorize(helper.PermMenuWrite), controller.UpdateFood())
+	incommingRoutes.PATCH("/food/:id/override", middleware.Authorize(helper.PermMenuWrite), controller.UpdateFoodOverride())
+	incommingRoutes.DELETE("/food/:id/override", middleware.Authorize(helper.PermMenuWrite), controller.DeleteFoodOverride())
 	// incommingRoutes.DELETE("/food/:id", controller.DeleteFood())
 }
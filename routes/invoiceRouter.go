@@ -2,13 +2,15 @@ package routes
 
 import (
 	"resturnat-management/controller"
+	"resturnat-management/helper"
+	"resturnat-management/middleware"
 
 	"github.com/gin-gonic/gin"
 )
 
 func InvoiceRouter(incommingRoutes *gin.Engine) {
-	incommingRoutes.POST("/invoice", controller.CreateInvoice())
-	incommingRoutes.GET("/invoice/:id", controller.GetAllInvoice())
-	incommingRoutes.GET("/invoices", controller.GetInvoices())
-	incommingRoutes.PATCH("/invoice/:id", controller.UpdateInvoice())
+	incommingRoutes.POST("/invoice", middleware.Authorize(helper.PermInvoicesWrite), middleware.Idempotent(), controller.CreateInvoice())
+	incommingRoutes.GET("/invoice/:id", middleware.Authorize(helper.PermInvoicesRead), controller.GetAllInvoice())
+	incommingRoutes.GET("/invoices", middleware.Authorize(helper.PermInvoicesRead), controller.GetInvoices())
+	incommingRoutes.PATCH("/invoice/:id", middleware.Authorize(helper.PermInvoicesWrite), controller.UpdateInvoice())
 }
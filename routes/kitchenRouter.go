@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"resturnat-management/controller"
+	"resturnat-management/helper"
+	"resturnat-management/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func KitchenRouter(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/kitchen/stream", middleware.Authorize(helper.PermOrdersRead), controller.StreamKitchenEvents())
+	incomingRoutes.GET("/kitchen/queue", middleware.Authorize(helper.PermOrdersRead), controller.GetStationQueue())
+}
@@ -2,15 +2,17 @@ package routes
 
 import (
 	controller "resturnat-management/controller"
+	"resturnat-management/helper"
+	"resturnat-management/middleware"
 
 	"github.com/gin-gonic/gin"
 )
 
 func MenuRouter(incomingRoutes *gin.Engine) {
 	// Use middleware if needed
-	incomingRoutes.POST("/menu", controller.CreateMenu())
-	incomingRoutes.GET("/menu/:menu_id", controller.GetMenu())
-	incomingRoutes.GET("/menus", controller.GetAllMenus())
-	incomingRoutes.PATCH("/menu/:menu_id", controller.UpdateMenu())
+	incomingRoutes.POST("/menu", middleware.Authorize(helper.PermMenuWrite), controller.CreateMenu())
+	incomingRoutes.GET("/menu/:menu_id", middleware.Authorize(helper.PermMenuRead), controller.GetMenu())
+	incomingRoutes.GET("/menus", middleware.Authorize(helper.PermMenuRead), controller.GetAllMenus())
+	incomingRoutes.PATCH("/menu/:menu_id", middleware.Authorize(helper.PermMenuWrite), controller.UpdateMenu())
 	// incomingRoutes.DELETE("/menu/:id", controller.DeleteMenu())
 }
@@ -2,6 +2,8 @@ package routes
 
 import (
 	"resturnat-management/controller"
+	"resturnat-management/helper"
+	"resturnat-management/middleware"
 
 	"github.com/gin-gonic/gin"
 )
@@ -10,7 +12,7 @@ func NoteRouter(incomingRoutes *gin.Engine) {
 
 	// if want to put like /note/createNote like this then grouping can be used
 	// noteRoutes := incomingRoutes.Group("/note")
-	incomingRoutes.POST("/createNote", controller.CreateNote())
-	incomingRoutes.GET("/getNotes", controller.GetNotes())
-	incomingRoutes.GET("/getNote/:note_id", controller.GetNote())
+	incomingRoutes.POST("/createNote", middleware.Authorize(helper.PermNotesWrite), controller.CreateNote())
+	incomingRoutes.GET("/getNotes", middleware.Authorize(helper.PermNotesRead), controller.GetNotes())
+	incomingRoutes.GET("/getNote/:note_id", middleware.Authorize(helper.PermNotesRead), controller.GetNote())
 }
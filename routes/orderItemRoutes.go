@@ -2,14 +2,18 @@ package routes
 
 import (
 	"resturnat-management/controller"
+	"resturnat-management/helper"
+	"resturnat-management/middleware"
 
 	"github.com/gin-gonic/gin"
 )
 
 func OrderItemRouter(incommingRoutes *gin.Engine) {
-	incommingRoutes.POST("/orderitem", controller.CreateOrderItem())
-	incommingRoutes.GET("/orderitem/:id", controller.GetOrderItem())
-	incommingRoutes.GET("/orderitems", controller.GetAllOrderItems())
-	incommingRoutes.GET("/orderitems-order/:id", controller.GetOrderItemsByOrder())
-	incommingRoutes.PATCH("/orderitem/:id", controller.UpdateOrderItem())
+	incommingRoutes.POST("/orderitem", middleware.Authorize(helper.PermOrdersWrite), middleware.Idempotent(), controller.CreateOrderItem())
+	incommingRoutes.GET("/orderitem/:id", middleware.Authorize(helper.PermOrdersRead), controller.GetOrderItem())
+	incommingRoutes.GET("/orderitems", middleware.Authorize(helper.PermOrdersRead), controller.GetAllOrderItems())
+	incommingRoutes.GET("/orderitems-order/:id", middleware.Authorize(helper.PermOrdersRead), controller.GetOrderItemsByOrder())
+	incommingRoutes.PATCH("/orderitem/:id", middleware.Authorize(helper.PermOrdersWrite), controller.UpdateOrderItem())
+	incommingRoutes.POST("/orderitem/:id/kitchen-status", middleware.Authorize(helper.PermKitchenUpdate), controller.UpdateKitchenStatus())
+	incommingRoutes.POST("/orderitem/:id/void", middleware.Authorize(helper.PermOrdersWrite), controller.VoidOrderItem())
 }
@@ -2,13 +2,23 @@ package routes
 
 import (
 	"resturnat-management/controller"
+	"resturnat-management/helper"
+	"resturnat-management/middleware"
 
 	"github.com/gin-gonic/gin"
 )
 
 func OrderRouter(incommingRoutes *gin.Engine) {
-	incommingRoutes.POST("/order", controller.CreateOrder())
-	incommingRoutes.GET("/order/:order_id", controller.GetOrder())
-	incommingRoutes.GET("/orders", controller.GetAllOrders())
-	incommingRoutes.PATCH("/order/:order_id", controller.UpdateOrder())
+	incommingRoutes.POST("/order", middleware.Authorize(helper.PermOrdersWrite), middleware.Idempotent(), controller.CreateOrder())
+	incommingRoutes.GET("/order/:order_id", middleware.Authorize(helper.PermOrdersRead), controller.GetOrder())
+	incommingRoutes.GET("/orders", middleware.Authorize(helper.PermOrdersRead), controller.GetAllOrders())
+	incommingRoutes.PATCH("/order/:order_id", middleware.Authorize(helper.PermOrdersWrite), controller.UpdateOrder())
+	incommingRoutes.POST("/order/preview", middleware.Authorize(helper.PermOrdersRead), controller.PreviewOrder())
+	incommingRoutes.GET("/order/:order_id/ticket", middleware.Authorize(helper.PermOrdersRead), controller.GetKitchenTicket())
+	incommingRoutes.POST("/order/:order_id/courses/:course/fire", middleware.Authorize(helper.PermOrdersWrite), controller.FireCourse())
+	incommingRoutes.POST("/order/:order_id/move-items", middleware.Authorize(helper.PermOrdersWrite), controller.MoveOrderItems())
+	incommingRoutes.POST("/order/:order_id/split", middleware.Authorize(helper.PermOrdersWrite), controller.SplitOrder())
+	incommingRoutes.POST("/order/:order_id/merge", middleware.Authorize(helper.PermOrdersWrite), controller.MergeOrders())
+	incommingRoutes.POST("/order/:order_id/cancel", middleware.Authorize(helper.PermOrdersWrite), controller.CancelOrder())
+	incommingRoutes.POST("/order/:order_id/status", middleware.Authorize(helper.PermOrdersWrite), controller.TransitionOrder())
 }
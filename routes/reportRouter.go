@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"resturnat-management/controller"
+	"resturnat-management/helper"
+	"resturnat-management/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ReportRouter(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/reports/prep-times", middleware.Authorize(helper.PermReportsRead), controller.GetPrepTimeReport())
+	incomingRoutes.GET("/reports/voids", middleware.Authorize(helper.PermReportsRead), controller.GetVoidReport())
+}
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"resturnat-management/controller"
+	"resturnat-management/helper"
+	"resturnat-management/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func SettingRouter(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/settings", middleware.Authorize(helper.PermSettingsManage), controller.GetSettings())
+	incomingRoutes.PATCH("/settings", middleware.Authorize(helper.PermSettingsManage), controller.UpdateSettings())
+}
@@ -2,6 +2,8 @@ package routes
 
 import (
 	"resturnat-management/controller"
+	"resturnat-management/helper"
+	"resturnat-management/middleware"
 
 	"github.com/gin-gonic/gin"
 )
@@ -9,9 +11,9 @@ import (
 // modi
 func TableRouter(incomingRoutes *gin.Engine) {
 	// Use middleware if needed
-	incomingRoutes.POST("/table", controller.CreateTable())
-	incomingRoutes.GET("/table/:id", controller.GetTable())
-	incomingRoutes.GET("/tables", controller.GetAllTables())
-	incomingRoutes.PATCH("/table/:id", controller.UpdateTable())
+	incomingRoutes.POST("/table", middleware.Authorize(helper.PermTablesWrite), controller.CreateTable())
+	incomingRoutes.GET("/table/:id", middleware.Authorize(helper.PermTablesRead), controller.GetTable())
+	incomingRoutes.GET("/tables", middleware.Authorize(helper.PermTablesRead), controller.GetAllTables())
+	incomingRoutes.PATCH("/table/:id", middleware.Authorize(helper.PermTablesWrite), controller.UpdateTable())
 	// incomingRoutes.DELETE("/table/:id", controller.DeleteTable())
 }
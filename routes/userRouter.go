@@ -2,6 +2,8 @@ package routes
 
 import (
 	controller "resturnat-management/controller"
+	"resturnat-management/helper"
+	"resturnat-management/middleware"
 
 	"github.com/gin-gonic/gin"
 )
@@ -11,8 +13,16 @@ func UserRouter(incommingRoutes *gin.Engine) {
 	// Use middleware if needed
 	incommingRoutes.POST("/signup", controller.Signup())
 	incommingRoutes.POST("/user/login", controller.Login())
+	incommingRoutes.POST("/user/login/2fa", controller.LoginTwoFactor())
 
-	incommingRoutes.GET("/user/:id", controller.GetUser())
+	// these routes are registered before the global auth middleware so they carry it themselves
+	incommingRoutes.GET("/user/:id", middleware.Authentication(), controller.GetUser())
+	incommingRoutes.POST("/users", middleware.Authentication(), middleware.Authorize(helper.PermUsersManage), controller.CreateUser())
+	incommingRoutes.PATCH("/user/:id/role", middleware.Authentication(), middleware.Authorize(helper.PermUsersManage), controller.UpdateUserRole())
+	incommingRoutes.POST("/user/2fa/enroll", middleware.EnrollmentAuthentication(), controller.EnrollTwoFactor())
+	incommingRoutes.POST("/user/2fa/verify", middleware.EnrollmentAuthentication(), controller.VerifyTwoFactor())
+	incommingRoutes.POST("/user/2fa/disable", middleware.Authentication(), controller.DisableTwoFactor())
+	incommingRoutes.PUT("/user/approval-pin", middleware.Authentication(), middleware.Authorize(helper.PermVoidApprove), controller.SetApprovalPin())
 	// incommingRoutes.GET("/users", controller.GetAllUsers())
 
 	// if i want to i will in future if it is needed
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"resturnat-management/controller"
+	"resturnat-management/helper"
+	"resturnat-management/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func WebhookRouter(incomingRoutes *gin.Engine) {
+	incomingRoutes.POST("/webhooks", middleware.Authorize(helper.PermWebhooksManage), controller.CreateWebhook())
+	incomingRoutes.GET("/webhooks", middleware.Authorize(helper.PermWebhooksManage), controller.GetWebhooks())
+	incomingRoutes.PATCH("/webhooks/:webhook_id", middleware.Authorize(helper.PermWebhooksManage), controller.UpdateWebhook())
+	incomingRoutes.DELETE("/webhooks/:webhook_id", middleware.Authorize(helper.PermWebhooksManage), controller.DeleteWebhook())
+	incomingRoutes.GET("/webhook-deliveries", middleware.Authorize(helper.PermWebhooksManage), controller.GetWebhookDeliveries())
+	incomingRoutes.POST("/webhook-deliveries/:delivery_id/retry", middleware.Authorize(helper.PermWebhooksManage), controller.RetryWebhookDelivery())
+}
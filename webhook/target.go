@@ -0,0 +1,101 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ranges that aren't covered by the netip checks but aren't on the public internet either
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	// carrier grade nat, also used for internal services by some clouds
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	// nat64, which can reach any ipv4 address behind it
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+var errPrivateTarget = errors.New("webhooks can only be sent to public addresses")
+
+// publicAddress reports whether an address is on the public internet. loopback, private,
+// link-local (which has the cloud metadata services) and reserved addresses are not, a webhook
+// must never be able to reach the services next to the api
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckUrl makes sure deliveries can be posted to a url: it has to be an absolute http or
+// https url whose host resolves to public addresses only. the addresses are checked again
+// every time a delivery connects, see NewClient, since a name can resolve differently later
+func CheckUrl(ctx context.Context, raw string) error {
+	target, err := url.Parse(raw)
+	if err != nil || (target.Scheme != "https" && target.Scheme != "http") || target.Hostname() == "" {
+		return errors.New("url must be an absolute http or https url")
+	}
+	if target.User != nil {
+		return errors.New("url can't carry a user or password")
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", target.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("the host %s could not be resolved", target.Hostname())
+	}
+	for _, addr := range addrs {
+		if !publicAddress(addr) {
+			return errPrivateTarget
+		}
+	}
+	return nil
+}
+
+// NewClient is the http client deliveries are sent with. it only connects to public addresses,
+// whatever a url's host resolves to at the time, doesn't use a proxy and doesn't follow
+// redirects, a redirect counts as a failed attempt
+func NewClient(timeout time.Duration) *http.Client {
+	return newClient(timeout, publicAddress)
+}
+
+func newClient(timeout time.Duration, allowed func(netip.Addr) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		// runs after the name was resolved, right before connecting to the address
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !allowed(addrPort.Addr()) {
+				return errPrivateTarget
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
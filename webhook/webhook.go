@@ -0,0 +1,161 @@
+// Package webhook signs and sends webhook deliveries and works out when a failed one is tried
+// again. it doesn't touch the database, subscriptions and the delivery log live with the
+// controllers.
+//
+// a delivery is a POST of the event as JSON. its Webhook-Signature header carries the unix time
+// it was sent and an HMAC-SHA256 of "<time>.<body>" keyed with the subscription's secret, as in
+// t=1700000000,v1=5257a869..., so a receiver can check it came from us and refuse old replays
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SignatureHeader = "Webhook-Signature"
+	EventHeader     = "Webhook-Event"
+	DeliveryHeader  = "Webhook-Delivery"
+)
+
+// a delivery that failed this many times is given up on and goes to the dead letters
+const MaxAttempts = 8
+
+// the wait after the first failure, it doubles with every failure after that up to maxRetry
+const (
+	firstRetry = 30 * time.Second
+	maxRetry   = 6 * time.Hour
+)
+
+// only the start of a receiver's answer to a failed attempt is kept for the delivery log
+const maxResponseLength = 256
+
+var (
+	errBadSignature = errors.New("the signature doesn't match")
+	errStale        = errors.New("the signature is too old")
+)
+
+// Event is the body of a delivery. Id is the event's id, it stays the same when a delivery is
+// retried so a receiver can drop repeats
+type Event struct {
+	Id         string                 `json:"id"`
+	Type       string                 `json:"type"`
+	Created_at time.Time              `json:"created_at"`
+	Data       map[string]interface{} `json:"data"`
+}
+
+// Request is one attempt at a delivery
+type Request struct {
+	Url         string
+	Secret      string
+	Event_type  string
+	Delivery_id string
+	Body        []byte
+}
+
+// Result is how an attempt went. Err is set when the receiver couldn't be reached or didn't
+// answer with a 2xx. Response is the start of the answer to a failed attempt, a successful
+// one's isn't kept
+type Result struct {
+	Status_code int
+	Response    string
+	Duration    time.Duration
+	Err         error
+}
+
+// NewSecret makes a secret for a subscription that didn't bring its own
+func NewSecret() (string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
+
+// Sign is the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the secret
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Signature is the Webhook-Signature header of a body sent at a time
+func Signature(secret string, at time.Time, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", at.Unix(), Sign(secret, at.Unix(), body))
+}
+
+// Verify checks a Webhook-Signature header the way a receiver should: the signature has to
+// match the body and be no older than tolerance
+func Verify(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var timestamp int64
+	var signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signature = value
+		}
+	}
+	if timestamp == 0 || signature == "" {
+		return errBadSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body))) {
+		return errBadSignature
+	}
+	if age := now.Sub(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return errStale
+	}
+	return nil
+}
+
+// Send makes one attempt at a delivery, signed as of now
+func Send(ctx context.Context, client *http.Client, request Request, now time.Time) Result {
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, request.Url, bytes.NewReader(request.Body))
+	if err != nil {
+		return Result{Err: err}
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set(EventHeader, request.Event_type)
+	httpRequest.Header.Set(DeliveryHeader, request.Delivery_id)
+	httpRequest.Header.Set(SignatureHeader, Signature(request.Secret, now, request.Body))
+
+	start := time.Now()
+	response, err := client.Do(httpRequest)
+	result := Result{Duration: time.Since(start)}
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer response.Body.Close()
+
+	result.Status_code = response.StatusCode
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		answer, _ := io.ReadAll(io.LimitReader(response.Body, maxResponseLength))
+		result.Response = string(answer)
+		result.Err = fmt.Errorf("the receiver answered %d", response.StatusCode)
+	}
+	return result
+}
+
+// Backoff is how long to wait before trying again after a delivery failed attempts times
+func Backoff(attempts int) time.Duration {
+	wait := firstRetry
+	for i := 1; i < attempts && wait < maxRetry; i++ {
+		wait *= 2
+	}
+	return min(wait, maxRetry)
+}